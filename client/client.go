@@ -0,0 +1,256 @@
+// Package client lets applications push gauges and counters to the metrics
+// server using the same wire formats as the agent.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+type Mode int
+
+const (
+	// ModeBatch sends all metrics in one request to /updates/.
+	ModeBatch Mode = iota
+	// ModeJSON sends one JSON request per metric to /update/.
+	ModeJSON
+	// ModePlain sends one request per metric to /update/<type>/<name>/<value>.
+	ModePlain
+)
+
+type Config struct {
+	// Addr is the server address, with or without the http:// prefix.
+	Addr    string
+	HashKey string
+	Mode    Mode
+	Timeout time.Duration
+
+	HTTPClient *http.Client
+}
+
+type Client struct {
+	cfg  Config
+	base string
+	http *http.Client
+
+	mu       sync.Mutex
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+func New(cfg Config) *Client {
+	base := strings.TrimRight(cfg.Addr, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	hc := cfg.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: cfg.Timeout}
+	}
+	return &Client{
+		cfg:      cfg,
+		base:     base,
+		http:     hc,
+		gauges:   map[string]float64{},
+		counters: map[string]int64{},
+	}
+}
+
+// Gauge sets the value reported for name on the next push.
+func (c *Client) Gauge(name string, val float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gauges[name] = val
+}
+
+// Add increments the counter name by delta until the next successful push.
+func (c *Client) Add(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counters[name] += delta
+}
+
+// Inc increments the counter name by one.
+func (c *Client) Inc(name string) {
+	c.Add(name, 1)
+}
+
+// Push sends the current values. Counters are decreased by the amount that
+// was acknowledged by the server, so concurrent Add calls are not lost.
+func (c *Client) Push(ctx context.Context) error {
+	batch := c.snapshot()
+	if len(batch) == 0 {
+		return nil
+	}
+	if c.cfg.Mode == ModeBatch {
+		if err := c.sendBatch(ctx, batch); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		c.acknowledge(batch)
+		return nil
+	}
+
+	var errs []string
+	for i := range batch {
+		if err := c.sendMetric(ctx, batch[i]); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		c.acknowledge(batch[i : i+1])
+	}
+	if len(errs) > 0 {
+		return clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+// Run pushes on every interval until ctx is done, passing push errors to
+// onError when it is not nil. A final push is made on exit.
+func (c *Client) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := c.Push(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := c.Push(flushCtx); err != nil && onError != nil {
+				onError(err)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+func (c *Client) snapshot() []metric.Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	batch := make([]metric.Metric, 0, len(c.gauges)+len(c.counters))
+	for name, val := range c.gauges {
+		val := val
+		batch = append(batch, metric.Metric{
+			ID:    name,
+			MType: Gauge,
+			Value: &val,
+		})
+	}
+	for name, del := range c.counters {
+		if del == 0 {
+			continue
+		}
+		del := del
+		batch = append(batch, metric.Metric{
+			ID:    name,
+			MType: Counter,
+			Delta: &del,
+		})
+	}
+	return batch
+}
+
+func (c *Client) acknowledge(sent []metric.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range sent {
+		if sent[i].MType == Counter && sent[i].Delta != nil {
+			c.counters[sent[i].ID] -= *sent[i].Delta
+		}
+	}
+}
+
+func (c *Client) sendMetric(ctx context.Context, m metric.Metric) error {
+	if err := m.UpdateHash(c.cfg.HashKey); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	switch c.cfg.Mode {
+	case ModePlain:
+		var val string
+		switch m.MType {
+		case Gauge:
+			val = strconv.FormatFloat(*m.Value, 'f', -1, 64)
+		case Counter:
+			val = strconv.FormatInt(*m.Delta, 10)
+		}
+		u := c.base + "/update/" + m.MType + "/" + url.PathEscape(m.ID) + "/" + val
+		if err := c.post(ctx, u, "text/plain", m.Hash, nil); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	case ModeJSON:
+		body, err := json.Marshal(m)
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		if err := c.post(ctx, c.base+"/update/", "application/json", m.Hash, body); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	default:
+		return clog.ToLog(clog.FuncName(), errors.New("unsupported mode"))
+	}
+	return nil
+}
+
+func (c *Client) sendBatch(ctx context.Context, batch []metric.Metric) error {
+	var body []byte
+	for i := range batch {
+		m := batch[i]
+		if err := m.UpdateHash(c.cfg.HashKey); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		mj, err := json.Marshal(m)
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		body = append(body, mj...)
+		body = append(body, ',')
+	}
+	if err := c.post(ctx, c.base+"/updates/", "application/json", "", body); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, u, contentType, hash string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if hash != "" {
+		req.Header.Set("Hash", hash)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return clog.ToLog(clog.FuncName(), errors.New("unexpected response status <"+res.Status+">: "+strings.TrimSpace(string(msg))))
+	}
+	return nil
+}
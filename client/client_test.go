@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Push(t *testing.T) {
+	var paths []string
+	var bodies []string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		paths = append(paths, r.URL.Path)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	c := New(Config{Addr: ts.URL, Mode: ModePlain})
+	c.Gauge("Temp", 1.5)
+	c.Add("Hits", 2)
+	status = http.StatusInternalServerError
+	assert.Error(t, c.Push(context.Background()))
+	assert.Equal(t, int64(2), c.counters["Hits"])
+
+	paths = nil
+	status = http.StatusOK
+	assert.NoError(t, c.Push(context.Background()))
+	assert.ElementsMatch(t, []string{"/update/gauge/Temp/1.5", "/update/counter/Hits/2"}, paths)
+	assert.Equal(t, int64(0), c.counters["Hits"])
+
+	c = New(Config{Addr: ts.URL, HashKey: "key"})
+	c.Inc("Hits")
+	paths, bodies = nil, nil
+	assert.NoError(t, c.Push(context.Background()))
+	assert.Equal(t, []string{"/updates/"}, paths)
+	assert.Contains(t, bodies[0], `"id":"Hits","type":"counter","delta":1,"hash":"`)
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"log"
+	"os"
 	"time"
 
 	"github.com/dcaiman/YP_GO/internal/agent"
 	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/compress"
 )
 
 func main() {
@@ -16,13 +17,31 @@ func main() {
 			ReportInterval: 6 * time.Second,
 			SrvAddr:        "127.0.0.1:8080",
 			HashKey:        "key",
+			FanoutPolicy:   agent.FanoutReplicate,
+			RetryCount:     3,
+			RetryMinDelay:  500 * time.Millisecond,
+			RetryMaxDelay:  5 * time.Second,
+			RetryJitter:    0.2,
+			SpoolDir:       "./tmp/agentSpool",
+			SpoolLimit:     100,
+			RateLimit:      4,
+			Compress:       compress.Gzip,
+			LogLevel:       "info",
+			LogFormat:      clog.FormatLogfmt,
 			ArgConfig:      true,
 			EnvConfig:      true,
 			SendBatch:      true,
 		},
 	}
 	if err := agn.GetExternalConfig(); err != nil {
-		log.Println(clog.ToLog(clog.FuncName(), err))
+		clog.Error("config", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		os.Exit(2)
+	}
+	if agn.Cfg.PrintConfig {
+		if err := agn.PrintConfig(os.Stdout); err != nil {
+			clog.Error("config", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			os.Exit(1)
+		}
 		return
 	}
 	agent.RunAgent(&agn)
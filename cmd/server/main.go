@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"os"
 	"time"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
@@ -13,10 +13,20 @@ func main() {
 		Cfg: server.EnvConfig{
 			SrvAddr: "127.0.0.1:8080",
 			//DBAddr:        "postgresql://postgres:1@127.0.0.1:5432",
+			DBMaxConns:    10,
 			StoreInterval: 0 * time.Second,
 			StoreFile:     "./tmp/metricStorage.json",
 			HashKey:       "key",
 			InitDownload:  true,
+			MaxBodySize:   16 << 20,
+
+			ScrapeInterval: 10 * time.Second,
+
+			ReadyTimeout:        2 * time.Second,
+			ReadyPoolSaturation: 0.9,
+
+			LogLevel:  "info",
+			LogFormat: clog.FormatLogfmt,
 
 			ArgConfig: true,
 			EnvConfig: true,
@@ -24,7 +34,14 @@ func main() {
 		},
 	}
 	if err := srv.GetExternalConfig(); err != nil {
-		log.Println(clog.ToLog(clog.FuncName(), err))
+		clog.Error("config", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		os.Exit(2)
+	}
+	if srv.Cfg.PrintConfig {
+		if err := srv.PrintConfig(os.Stdout); err != nil {
+			clog.Error("config", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			os.Exit(1)
+		}
 		return
 	}
 	server.RunServer(&srv)
@@ -2,57 +2,25 @@ package agent
 
 import (
 	"flag"
-	"log"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/compress"
+	"github.com/dcaiman/YP_GO/internal/config"
 	"github.com/dcaiman/YP_GO/internal/internalstorage"
 	"github.com/dcaiman/YP_GO/internal/metric"
 
 	"github.com/caarlos0/env"
 )
 
-var runtimeGauges = [...]string{
-	"Alloc",
-	"BuckHashSys",
-	"Frees",
-	"GCCPUFraction",
-	"GCSys",
-	"HeapAlloc",
-	"HeapIdle",
-	"HeapInuse",
-	"HeapObjects",
-	"HeapReleased",
-	"HeapSys",
-	"LastGC",
-	"Lookups",
-	"MCacheInuse",
-	"MCacheSys",
-	"MSpanInuse",
-	"MSpanSys",
-	"Mallocs",
-	"NextGC",
-	"NumForcedGC",
-	"NumGC",
-	"OtherSys",
-	"PauseTotalNs",
-	"StackInuse",
-	"StackSys",
-	"Sys",
-	"TotalAlloc",
-}
-
-var customGauges = [...]string{
-	"RandomValue",
-}
-
-var counters = [...]string{
-	"PollCount",
-}
-
 const (
 	Gauge       = "gauge"
 	Counter     = "counter"
@@ -67,8 +35,53 @@ type EnvConfig struct {
 	SrvAddr        string        `env:"ADDRESS"`
 	HashKey        string        `env:"KEY"`
 
+	FanoutPolicy string `env:"FANOUT_POLICY"`
+
+	AgentID string   `env:"AGENT_ID"`
+	Labels  []string `env:"AGENT_LABELS"`
+
+	RetryCount    int           `env:"RETRY_COUNT"`
+	RetryMinDelay time.Duration `env:"RETRY_MIN_DELAY"`
+	RetryMaxDelay time.Duration `env:"RETRY_MAX_DELAY"`
+	RetryJitter   float64       `env:"RETRY_JITTER"`
+
+	SpoolDir   string `env:"SPOOL_DIR"`
+	SpoolLimit int    `env:"SPOOL_LIMIT"`
+
+	RateLimit int `env:"RATE_LIMIT"`
+
+	Compress string `env:"COMPRESS"`
+
+	Collectors         []string `env:"COLLECTORS"`
+	CollectorIntervals []string `env:"COLLECTOR_INTERVALS"`
+
+	ProcPath string `env:"PROC_PATH"`
+	SysPath  string `env:"SYS_PATH"`
+
+	ProcWatch []string `env:"PROC_WATCH"`
+
+	ExecCommands []string      `env:"EXEC_COMMANDS" envSeparator:";"`
+	ExecTimeout  time.Duration `env:"EXEC_TIMEOUT"`
+
+	StatsdAddr string `env:"STATSD_ADDRESS"`
+	StatsdTCP  bool   `env:"STATSD_TCP"`
+
+	LogRulesFile string `env:"LOG_RULES_FILE"`
+
+	AggregateGauges []string `env:"AGGREGATE_GAUGES"`
+	AggregateFuncs  []string `env:"AGGREGATE_FUNCS"`
+
+	PullAddr string `env:"PULL_ADDRESS"`
+
+	LogLevel  string `env:"LOG_LEVEL"`
+	LogFormat string `env:"LOG_FORMAT"`
+	LogOutput string `env:"LOG_OUTPUT"`
+
 	CType string
 
+	ConfigFile  string
+	PrintConfig bool
+
 	EnvConfig bool
 	ArgConfig bool
 	SendBatch bool
@@ -77,53 +90,274 @@ type EnvConfig struct {
 type AgentConfig struct {
 	Storage metric.MStorage
 	Cfg     EnvConfig
+
+	spool      *spool
+	collectors []activeCollector
+	aggregates *aggregatingStorage
+	exposed    metric.MStorage
+	statsd     statsdState
+
+	labels map[string]string
+
+	dests   []*destination
+	ackedMu sync.Mutex
+	acked   map[*destination]map[string]int64
+
+	jobs      chan sendJob
+	inFlight  int64
+	reporting int32
 }
 
 func RunAgent(agn *AgentConfig) {
-	log.Println("AGENT CONFIG: ", agn.Cfg)
+	if err := agn.initIdentity(); err != nil {
+		clog.Error("agent identity", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		return
+	}
+	clog.Info("agent config", clog.F("agent", agn.Cfg.AgentID), clog.F("config", fmt.Sprintf("%+v", agn.Cfg)))
 
 	fileStorage := internalstorage.New("", agn.Cfg.HashKey)
 	agn.Storage = fileStorage
 
+	if len(agn.Cfg.AggregateGauges) > 0 {
+		as, err := newAggregatingStorage(fileStorage, agn.Cfg.AggregateGauges, agn.Cfg.AggregateFuncs)
+		if err != nil {
+			clog.Error("gauge aggregation", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			return
+		}
+		agn.aggregates = as
+		agn.Storage = as
+	}
+
+	if agn.Cfg.PullAddr != "" {
+		agn.exposed = internalstorage.New("", agn.Cfg.HashKey)
+		agn.startPullServer()
+	}
+
+	if err := agn.initDestinations(); err != nil {
+		clog.Error("destinations", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		return
+	}
+
+	if agn.Cfg.SpoolDir != "" && len(agn.dests) > 0 {
+		if agn.canSpool() {
+			sp, err := newSpool(agn.Cfg.SpoolDir, agn.Cfg.SpoolLimit)
+			if err != nil {
+				clog.Error("spool", clog.F("dir", agn.Cfg.SpoolDir), clog.Err(clog.ToLog(clog.FuncName(), err)))
+			}
+			agn.spool = sp
+		} else {
+			clog.Info("spool disabled, unacknowledged counters are kept per destination", clog.F("policy", agn.Cfg.FanoutPolicy))
+		}
+	}
+
+	agn.startWorkers()
+
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
-	pollTimer := time.NewTicker(agn.Cfg.PollInterval)
-	reportTimer := time.NewTicker(agn.Cfg.ReportInterval)
-
+	if err := agn.initCollectors(); err != nil {
+		clog.Error("collectors", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		return
+	}
 	if err := agn.prepareStorage(); err != nil {
-		log.Println(clog.ToLog(clog.FuncName(), err))
+		clog.Error("storage", clog.Err(clog.ToLog(clog.FuncName(), err)))
 	}
+	agn.startCollectors()
+
+	if agn.Cfg.StatsdAddr != "" {
+		if err := agn.startStatsd(); err != nil {
+			clog.Error("statsd", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		}
+	}
+
+	reportTimer := time.NewTicker(agn.Cfg.ReportInterval)
 
 	for {
 		select {
-		case <-pollTimer.C:
-			if err := agn.poll(); err != nil {
-				log.Println(clog.ToLog(clog.FuncName(), err))
-			}
 		case <-reportTimer.C:
-			if err := agn.report(agn.Cfg.SendBatch); err != nil {
-				log.Println(clog.ToLog(clog.FuncName(), err))
-			}
+			agn.reportTick()
 		case <-signalCh:
-			log.Println("EXIT")
+			clog.Info("exit")
 			os.Exit(0)
 		}
 	}
 }
 
+// reportTick closes the report window and pushes it, when there is anywhere
+// to push to.
+func (agn *AgentConfig) reportTick() {
+	if err := agn.flushWindows(); err != nil {
+		clog.Error("flush failed", clog.Err(clog.ToLog(clog.FuncName(), err)))
+	}
+	if len(agn.dests) == 0 {
+		return
+	}
+	agn.startReport()
+}
+
+// startReport reports in the background unless the previous report is
+// still in progress.
+func (agn *AgentConfig) startReport() bool {
+	if !atomic.CompareAndSwapInt32(&agn.reporting, 0, 1) {
+		clog.Warn("report skipped, previous report is still in progress", clog.F("in_flight", agn.InFlight()))
+		return false
+	}
+	go func() {
+		defer atomic.StoreInt32(&agn.reporting, 0)
+		if err := agn.report(agn.Cfg.SendBatch); err != nil {
+			clog.Error("report failed", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		}
+	}()
+	return true
+}
+
+// GetExternalConfig applies, in order of precedence, the config file,
+// env variables and command line flags over the defaults.
 func (agn *AgentConfig) GetExternalConfig() error {
+	path := ""
+	if agn.Cfg.EnvConfig {
+		path = os.Getenv("CONFIG")
+	}
 	if agn.Cfg.ArgConfig {
-		flag.StringVar(&agn.Cfg.SrvAddr, "a", agn.Cfg.SrvAddr, "server address")
-		flag.DurationVar(&agn.Cfg.ReportInterval, "r", agn.Cfg.ReportInterval, "report interval")
-		flag.DurationVar(&agn.Cfg.PollInterval, "p", agn.Cfg.PollInterval, "poll interval")
-		flag.StringVar(&agn.Cfg.HashKey, "k", agn.Cfg.HashKey, "hash key")
-		flag.Parse()
+		// flags are applied last, only the file path is needed before that
+		scratch := agn.Cfg
+		fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		bindFlags(fs, &scratch)
+		fs.Parse(os.Args[1:])
+		if scratch.ConfigFile != "" {
+			path = scratch.ConfigFile
+		}
+	}
+	if path != "" {
+		if err := config.Load(path, &agn.Cfg); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		agn.Cfg.ConfigFile = path
 	}
 	if agn.Cfg.EnvConfig {
 		if err := env.Parse(&agn.Cfg); err != nil {
 			return clog.ToLog(clog.FuncName(), err)
 		}
 	}
+	if agn.Cfg.ArgConfig {
+		bindFlags(flag.CommandLine, &agn.Cfg)
+		flag.Parse()
+	}
+	if err := agn.Cfg.Validate(); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := clog.Setup(agn.Cfg.LogLevel, agn.Cfg.LogFormat, agn.Cfg.LogOutput); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func bindFlags(fs *flag.FlagSet, cfg *EnvConfig) {
+	fs.StringVar(&cfg.ConfigFile, "c", cfg.ConfigFile, "config file, .json, .yaml or .yml")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", cfg.PrintConfig, "print the effective config and exit")
+	fs.StringVar(&cfg.SrvAddr, "a", cfg.SrvAddr, "comma separated server addresses, empty to disable push")
+	fs.StringVar(&cfg.AgentID, "id", cfg.AgentID, "agent id, machine id or hostname by default")
+	fs.Func("labels", "comma separated list of key=value labels attached to every metric", func(s string) error {
+		cfg.Labels = strings.Split(s, ",")
+		return nil
+	})
+	fs.StringVar(&cfg.FanoutPolicy, "fanout", cfg.FanoutPolicy, "multiple servers policy: replicate, failover or hash")
+	fs.DurationVar(&cfg.ReportInterval, "r", cfg.ReportInterval, "report interval")
+	fs.DurationVar(&cfg.PollInterval, "p", cfg.PollInterval, "poll interval")
+	fs.StringVar(&cfg.HashKey, "k", cfg.HashKey, "hash key")
+	fs.IntVar(&cfg.RetryCount, "retry", cfg.RetryCount, "retry count")
+	fs.DurationVar(&cfg.RetryMinDelay, "retry-min", cfg.RetryMinDelay, "min retry delay")
+	fs.DurationVar(&cfg.RetryMaxDelay, "retry-max", cfg.RetryMaxDelay, "max retry delay")
+	fs.Float64Var(&cfg.RetryJitter, "retry-jitter", cfg.RetryJitter, "retry jitter fraction")
+	fs.StringVar(&cfg.SpoolDir, "s", cfg.SpoolDir, "spool directory")
+	fs.IntVar(&cfg.SpoolLimit, "spool-limit", cfg.SpoolLimit, "max spool segments")
+	fs.IntVar(&cfg.RateLimit, "l", cfg.RateLimit, "max concurrent requests")
+	fs.StringVar(&cfg.Compress, "compress", cfg.Compress, "request body encoding: gzip, deflate, zstd, br, empty to disable")
+	fs.StringVar(&cfg.ProcPath, "proc", cfg.ProcPath, "procfs mount point")
+	fs.StringVar(&cfg.SysPath, "sys", cfg.SysPath, "sysfs mount point")
+	fs.Func("watch", "comma separated list of processes to watch, [label=]pidfile|name|cgroup:value", func(s string) error {
+		cfg.ProcWatch = strings.Split(s, ",")
+		return nil
+	})
+	execSet := false
+	fs.Func("exec", "external command producing metrics, [name=]command, may be repeated", func(s string) error {
+		// repeated flags add up, but replace commands set by the file or env
+		if !execSet {
+			cfg.ExecCommands = nil
+			execSet = true
+		}
+		cfg.ExecCommands = append(cfg.ExecCommands, s)
+		return nil
+	})
+	fs.DurationVar(&cfg.ExecTimeout, "exec-timeout", cfg.ExecTimeout, "external command timeout")
+	fs.StringVar(&cfg.StatsdAddr, "statsd", cfg.StatsdAddr, "statsd listen address")
+	fs.BoolVar(&cfg.StatsdTCP, "statsd-tcp", cfg.StatsdTCP, "also accept statsd over tcp")
+	fs.StringVar(&cfg.LogRulesFile, "log-rules", cfg.LogRulesFile, "log tailing rules file")
+	fs.Func("aggregate", "comma separated list of gauges to aggregate over the report window, * for all", func(s string) error {
+		cfg.AggregateGauges = strings.Split(s, ",")
+		return nil
+	})
+	fs.Func("aggregate-funcs", "comma separated list of min, max, mean, last, count", func(s string) error {
+		cfg.AggregateFuncs = strings.Split(s, ",")
+		return nil
+	})
+	fs.StringVar(&cfg.PullAddr, "pull", cfg.PullAddr, "address to expose metrics for scraping")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: logfmt or json")
+	fs.StringVar(&cfg.LogOutput, "log-output", cfg.LogOutput, "log output: stderr, stdout or file path")
+	fs.Func("collectors", "comma separated list of enabled collectors", func(s string) error {
+		cfg.Collectors = strings.Split(s, ",")
+		return nil
+	})
+	fs.Func("collector-intervals", "comma separated list of name=duration", func(s string) error {
+		cfg.CollectorIntervals = strings.Split(s, ",")
+		return nil
+	})
+}
+
+// PrintConfig writes the effective config in the format of the config file,
+// YAML when there is none.
+func (agn *AgentConfig) PrintConfig(w io.Writer) error {
+	format := config.FormatYAML
+	if agn.Cfg.ConfigFile != "" {
+		if f, err := config.FormatOf(agn.Cfg.ConfigFile); err == nil {
+			format = f
+		}
+	}
+	if err := config.Write(w, &agn.Cfg, format); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
 	return nil
 }
+
+// Validate reports every invalid setting at once.
+func (cfg *EnvConfig) Validate() error {
+	var p config.Problems
+	p.Check(cfg.PollInterval > 0, "poll_interval must be positive")
+	p.Check(cfg.ReportInterval > 0, "report_interval must be positive")
+	for _, addr := range strings.Split(cfg.SrvAddr, ",") {
+		addr = strings.TrimSpace(addr)
+		p.Check(addr == "" || config.ValidAddr(addr), "address <"+addr+"> is not host:port")
+	}
+	switch cfg.FanoutPolicy {
+	case "", FanoutReplicate, FanoutFailover, FanoutHash:
+	default:
+		p.Check(false, "fanout_policy must be replicate, failover or hash, got <"+cfg.FanoutPolicy+">")
+	}
+	p.Check(cfg.RetryCount >= 0, "retry_count must not be negative")
+	p.Check(cfg.RetryMinDelay >= 0 && cfg.RetryMaxDelay >= 0, "retry delays must not be negative")
+	p.Check(cfg.RetryMaxDelay == 0 || cfg.RetryMaxDelay >= cfg.RetryMinDelay, "retry_max_delay must not be less than retry_min_delay")
+	p.Check(cfg.RetryJitter >= 0 && cfg.RetryJitter <= 1, "retry_jitter must be within [0, 1]")
+	p.Check(cfg.SpoolLimit >= 0, "spool_limit must not be negative")
+	p.Check(cfg.RateLimit > 0, "rate_limit must be positive")
+	p.Check(cfg.Compress == "" || compress.IsSupported(cfg.Compress), "compress must be one of gzip, deflate, zstd, br or empty, got <"+cfg.Compress+">")
+	p.Check(cfg.ExecTimeout >= 0, "exec_timeout must not be negative")
+	p.Check(cfg.StatsdAddr == "" || config.ValidAddr(cfg.StatsdAddr), "statsd_address <"+cfg.StatsdAddr+"> is not host:port")
+	p.Check(cfg.PullAddr == "" || config.ValidAddr(cfg.PullAddr), "pull_address <"+cfg.PullAddr+"> is not host:port")
+	if _, err := clog.ParseLevel(cfg.LogLevel); err != nil {
+		p.Check(false, "log_level must be debug, info, warn or error, got <"+cfg.LogLevel+">")
+	}
+	p.Check(cfg.LogFormat == "" || cfg.LogFormat == clog.FormatLogfmt || cfg.LogFormat == clog.FormatJSON, "log_format must be logfmt or json, got <"+cfg.LogFormat+">")
+	return p.Err()
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+var aggregateFuncs = [...]string{
+	"min",
+	"max",
+	"mean",
+	"last",
+	"count",
+}
+
+type gaugeWindow struct {
+	min   float64
+	max   float64
+	sum   float64
+	last  float64
+	count int64
+}
+
+// aggregatingStorage observes gauge updates made by collectors and keeps
+// min/max/mean/last/count over the report window. flush writes them as
+// derived <id>_<func> gauges.
+type aggregatingStorage struct {
+	metric.MStorage
+
+	mu      sync.Mutex
+	all     bool
+	ids     map[string]bool
+	funcs   []string
+	windows map[string]*gaugeWindow
+}
+
+func newAggregatingStorage(st metric.MStorage, ids, funcs []string) (*aggregatingStorage, error) {
+	as := &aggregatingStorage{
+		MStorage: st,
+		ids:      map[string]bool{},
+		windows:  map[string]*gaugeWindow{},
+	}
+	for i := range ids {
+		id := strings.TrimSpace(ids[i])
+		switch id {
+		case "":
+		case "*":
+			as.all = true
+		default:
+			as.ids[id] = true
+		}
+	}
+	if len(funcs) == 0 {
+		funcs = aggregateFuncs[:]
+	}
+	for i := range funcs {
+		f := strings.TrimSpace(funcs[i])
+		if f == "" {
+			continue
+		}
+		if !isAggregateFunc(f) {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("unsupported aggregate <"+f+">"))
+		}
+		as.funcs = append(as.funcs, f)
+	}
+	return as, nil
+}
+
+func isAggregateFunc(f string) bool {
+	for i := range aggregateFuncs {
+		if aggregateFuncs[i] == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (as *aggregatingStorage) UpdateMetric(m metric.Metric) error {
+	as.observe([]metric.Metric{m})
+	if err := as.MStorage.UpdateMetric(m); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (as *aggregatingStorage) UpdateBatch(batch []metric.Metric) error {
+	as.observe(batch)
+	if err := as.MStorage.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (as *aggregatingStorage) observe(batch []metric.Metric) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	for i := range batch {
+		m := batch[i]
+		if m.MType != Gauge || m.Value == nil || !(as.all || as.ids[m.ID]) {
+			continue
+		}
+		v := *m.Value
+		w, ok := as.windows[m.ID]
+		if !ok {
+			w = &gaugeWindow{}
+			as.windows[m.ID] = w
+		}
+		if w.count == 0 {
+			w.min, w.max = v, v
+		}
+		w.min = math.Min(w.min, v)
+		w.max = math.Max(w.max, v)
+		w.sum += v
+		w.last = v
+		w.count++
+	}
+}
+
+// flush emits the aggregates of the window that is ending to dst and starts
+// a new one. Gauges that were not polled during the window only report
+// count 0. dst must not lead back to as, or the aggregates get aggregated.
+func (as *aggregatingStorage) flush(dst metric.MStorage) error {
+	as.mu.Lock()
+	ids := make([]string, 0, len(as.windows))
+	for id := range as.windows {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	batch := []metric.Metric{}
+	for _, id := range ids {
+		w := as.windows[id]
+		for _, f := range as.funcs {
+			if w.count == 0 && f != "count" {
+				continue
+			}
+			var val float64
+			switch f {
+			case "min":
+				val = w.min
+			case "max":
+				val = w.max
+			case "mean":
+				val = w.sum / float64(w.count)
+			case "last":
+				val = w.last
+			case "count":
+				val = float64(w.count)
+			}
+			batch = append(batch, gaugeMetric(id+"_"+f, val))
+		}
+		*w = gaugeWindow{}
+	}
+	as.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := dst.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
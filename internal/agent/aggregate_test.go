@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_aggregatingStorage(t *testing.T) {
+	st := internalstorage.New("", "")
+	as, err := newAggregatingStorage(st, []string{"Alloc"}, nil)
+	assert.NoError(t, err)
+
+	for _, v := range []float64{3, 9, 1, 4} {
+		assert.NoError(t, as.UpdateMetric(gaugeMetric("Alloc", v)))
+		assert.NoError(t, as.UpdateMetric(gaugeMetric("Other", v)))
+	}
+	assert.NoError(t, as.flush(st))
+
+	want := map[string]float64{"Alloc_min": 1, "Alloc_max": 9, "Alloc_mean": 4.25, "Alloc_last": 4, "Alloc_count": 4}
+	for id, v := range want {
+		m, err := st.GetMetric(id)
+		assert.NoError(t, err, id)
+		assert.Equal(t, v, *m.Value, id)
+	}
+	_, err = st.GetMetric("Other_max")
+	assert.Error(t, err)
+
+	assert.NoError(t, as.flush(st))
+	m, _ := st.GetMetric("Alloc_count")
+	assert.Equal(t, 0.0, *m.Value)
+
+	_, err = newAggregatingStorage(st, []string{"*"}, []string{"median"})
+	assert.Error(t, err)
+
+	// in pull mode the aggregates are exposed as well
+	st = internalstorage.New("", "")
+	as, err = newAggregatingStorage(st, []string{"*"}, []string{"max"})
+	require.NoError(t, err)
+	agn := AgentConfig{
+		Storage:    as,
+		aggregates: as,
+		exposed:    internalstorage.New("", ""),
+	}
+
+	for _, v := range []float64{3, 9} {
+		require.NoError(t, agn.collectStorage().UpdateMetric(gaugeMetric("Alloc", v)))
+	}
+	require.NoError(t, as.flush(agn.aggregateStorage()))
+	for _, s := range []metric.MStorage{st, agn.exposed} {
+		m, err := s.GetMetric("Alloc_max")
+		require.NoError(t, err)
+		assert.Equal(t, 9.0, *m.Value)
+	}
+
+	// derived gauges are not aggregated themselves
+	require.NoError(t, as.flush(agn.aggregateStorage()))
+	_, err = agn.exposed.GetMetric("Alloc_max_max")
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+// Collector is a source of metrics polled by the agent. Describe lists the
+// metrics known in advance so they can be created before the first poll,
+// Collect writes the current values into the agent storage.
+type Collector interface {
+	Describe() []metric.Metric
+	Collect(st metric.MStorage) error
+}
+
+type CollectorFactory func(cfg EnvConfig) (Collector, error)
+
+var (
+	collectorsMu sync.RWMutex
+	collectors   = map[string]CollectorFactory{}
+)
+
+var defaultCollectors = [...]string{
+	"runtime",
+	"random",
+	"exec",
+	"pollcount",
+}
+
+// RegisterCollector makes a collector available by name in the COLLECTORS
+// setting. It is meant to be called from init or main before RunAgent.
+func RegisterCollector(name string, factory CollectorFactory) {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+
+	if factory == nil {
+		panic("agent: RegisterCollector factory is nil")
+	}
+	if _, dup := collectors[name]; dup {
+		panic("agent: RegisterCollector called twice for " + name)
+	}
+	collectors[name] = factory
+}
+
+func Collectors() []string {
+	collectorsMu.RLock()
+	defer collectorsMu.RUnlock()
+
+	names := []string{}
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type activeCollector struct {
+	name      string
+	collector Collector
+	interval  time.Duration
+}
+
+func (agn *AgentConfig) initCollectors() error {
+	names := agn.Cfg.Collectors
+	if len(names) == 0 {
+		names = defaultCollectors[:]
+	}
+	intervals, err := parseCollectorIntervals(agn.Cfg.CollectorIntervals)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+
+	collectorsMu.RLock()
+	defer collectorsMu.RUnlock()
+
+	agn.collectors = nil
+	for i := range names {
+		name := strings.TrimSpace(names[i])
+		if name == "" {
+			continue
+		}
+		factory, ok := collectors[name]
+		if !ok {
+			return clog.ToLog(clog.FuncName(), errors.New("unknown collector <"+name+">"))
+		}
+		c, err := factory(agn.Cfg)
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), errors.New("collector <"+name+">: "+err.Error()))
+		}
+		interval := agn.Cfg.PollInterval
+		if d, ok := intervals[name]; ok {
+			interval = d
+		}
+		agn.collectors = append(agn.collectors, activeCollector{
+			name:      name,
+			collector: c,
+			interval:  interval,
+		})
+	}
+	return nil
+}
+
+func (agn *AgentConfig) startCollectors() {
+	for i := range agn.collectors {
+		go agn.runCollector(agn.collectors[i])
+	}
+}
+
+func (agn *AgentConfig) runCollector(ac activeCollector) {
+	clog.Info("collector started", clog.F("collector", ac.name), clog.F("interval", ac.interval))
+	timer := time.NewTicker(ac.interval)
+	defer timer.Stop()
+	for range timer.C {
+		if err := ac.collector.Collect(agn.collectStorage()); err != nil {
+			clog.Error("collect failed", clog.F("collector", ac.name), clog.Err(clog.ToLog(clog.FuncName(), err)))
+		}
+	}
+}
+
+// parseCollectorIntervals reads entries in the form name=duration.
+func parseCollectorIntervals(entries []string) (map[string]time.Duration, error) {
+	intervals := map[string]time.Duration{}
+	for i := range entries {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("invalid collector interval <"+entry+">, expected name=duration"))
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(val))
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		if d <= 0 {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("collector interval for <"+name+"> must be positive"))
+		}
+		intervals[strings.TrimSpace(name)] = d
+	}
+	return intervals, nil
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+var runtimeGauges = [...]string{
+	"Alloc",
+	"BuckHashSys",
+	"Frees",
+	"GCCPUFraction",
+	"GCSys",
+	"HeapAlloc",
+	"HeapIdle",
+	"HeapInuse",
+	"HeapObjects",
+	"HeapReleased",
+	"HeapSys",
+	"LastGC",
+	"Lookups",
+	"MCacheInuse",
+	"MCacheSys",
+	"MSpanInuse",
+	"MSpanSys",
+	"Mallocs",
+	"NextGC",
+	"NumForcedGC",
+	"NumGC",
+	"OtherSys",
+	"PauseTotalNs",
+	"StackInuse",
+	"StackSys",
+	"Sys",
+	"TotalAlloc",
+}
+
+var customGauges = [...]string{
+	"RandomValue",
+}
+
+var counters = [...]string{
+	"PollCount",
+}
+
+func init() {
+	RegisterCollector("runtime", func(cfg EnvConfig) (Collector, error) {
+		return runtimeCollector{}, nil
+	})
+	RegisterCollector("random", func(cfg EnvConfig) (Collector, error) {
+		return randomCollector{}, nil
+	})
+	RegisterCollector("pollcount", func(cfg EnvConfig) (Collector, error) {
+		return pollCountCollector{}, nil
+	})
+}
+
+type runtimeCollector struct{}
+
+func (runtimeCollector) Describe() []metric.Metric {
+	return describe(runtimeGauges[:], Gauge)
+}
+
+func (runtimeCollector) Collect(st metric.MStorage) error {
+	mem := &runtime.MemStats{}
+	runtime.ReadMemStats(mem)
+	batch := make([]metric.Metric, 0, len(runtimeGauges))
+	for i := range runtimeGauges {
+		val := getRuntimeMetricValue(mem, runtimeGauges[i])
+		batch = append(batch, metric.Metric{
+			ID:    runtimeGauges[i],
+			MType: Gauge,
+			Value: &val,
+		})
+	}
+	if err := st.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func getRuntimeMetricValue(mem *runtime.MemStats, name string) float64 {
+	return reflect.Indirect(reflect.ValueOf(mem)).FieldByName(name).Convert(reflect.TypeOf(0.0)).Float()
+}
+
+type randomCollector struct{}
+
+func (randomCollector) Describe() []metric.Metric {
+	return describe(customGauges[:], Gauge)
+}
+
+func (randomCollector) Collect(st metric.MStorage) error {
+	batch := make([]metric.Metric, 0, len(customGauges))
+	for i := range customGauges {
+		val := 100 * rand.Float64()
+		batch = append(batch, metric.Metric{
+			ID:    customGauges[i],
+			MType: Gauge,
+			Value: &val,
+		})
+	}
+	if err := st.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+type pollCountCollector struct{}
+
+func (pollCountCollector) Describe() []metric.Metric {
+	return describe(counters[:], Counter)
+}
+
+func (pollCountCollector) Collect(st metric.MStorage) error {
+	batch := make([]metric.Metric, 0, len(counters))
+	for i := range counters {
+		var del int64 = 1
+		batch = append(batch, metric.Metric{
+			ID:    counters[i],
+			MType: Counter,
+			Delta: &del,
+		})
+	}
+	if err := st.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func describe(names []string, mType string) []metric.Metric {
+	ms := make([]metric.Metric, 0, len(names))
+	for i := range names {
+		ms = append(ms, metric.Metric{
+			ID:    names[i],
+			MType: mType,
+		})
+	}
+	return ms
+}
+
+// deltas turns cumulative counters read from the system into the increments
+// the agent stores for counter metrics.
+type deltas struct {
+	sync.Mutex
+	prev map[string]int64
+}
+
+func (d *deltas) next(id string, cur int64) (int64, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.prev == nil {
+		d.prev = map[string]int64{}
+	}
+	prev, ok := d.prev[id]
+	d.prev[id] = cur
+	if !ok {
+		return 0, false
+	}
+	if cur < prev {
+		return cur, true
+	}
+	return cur - prev, true
+}
+
+func (d *deltas) counter(id string, cur int64) (metric.Metric, bool) {
+	del, ok := d.next(id, cur)
+	if !ok {
+		return metric.Metric{}, false
+	}
+	return metric.Metric{
+		ID:    id,
+		MType: Counter,
+		Delta: &del,
+	}, true
+}
+
+func gaugeMetric(id string, val float64) metric.Metric {
+	return metric.Metric{
+		ID:    id,
+		MType: Gauge,
+		Value: &val,
+	}
+}
+
+func metricSuffix(s string) string {
+	b := []byte(s)
+	for i := range b {
+		c := b[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	res := strings.Trim(string(b), "_")
+	if res == "" {
+		return "root"
+	}
+	return res
+}
@@ -0,0 +1,233 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const defaultExecTimeout = 10 * time.Second
+
+func init() {
+	RegisterCollector("exec", func(cfg EnvConfig) (Collector, error) {
+		return newExecCollector(cfg.ExecCommands, cfg.ExecTimeout)
+	})
+}
+
+type execCommand struct {
+	name    string
+	command string
+}
+
+type execCollector struct {
+	commands []execCommand
+	timeout  time.Duration
+}
+
+// newExecCollector accepts commands in the form [name=]command, the command
+// is run with sh -c so pipes and redirections are allowed.
+func newExecCollector(commands []string, timeout time.Duration) (*execCollector, error) {
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+	ec := &execCollector{
+		timeout: timeout,
+	}
+	for i := range commands {
+		entry := strings.TrimSpace(commands[i])
+		if entry == "" {
+			continue
+		}
+		cmd := execCommand{
+			name:    metricSuffix(strings.Fields(entry)[0]),
+			command: entry,
+		}
+		if name, rest, ok := strings.Cut(entry, "="); ok && !strings.ContainsAny(name, " \t") {
+			cmd.name = metricSuffix(name)
+			cmd.command = strings.TrimSpace(rest)
+		}
+		if cmd.command == "" {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("empty command <"+entry+">"))
+		}
+		ec.commands = append(ec.commands, cmd)
+	}
+	return ec, nil
+}
+
+func (ec *execCollector) Describe() []metric.Metric {
+	return nil
+}
+
+func (ec *execCollector) Collect(st metric.MStorage) error {
+	var errs []string
+	for i := range ec.commands {
+		batch, err := ec.run(ec.commands[i])
+		if err != nil {
+			errs = append(errs, "<"+ec.commands[i].name+">: "+err.Error())
+			var one int64 = 1
+			batch = []metric.Metric{{
+				ID:    "ExecFailures_" + ec.commands[i].name,
+				MType: Counter,
+				Delta: &one,
+			}}
+		}
+		if err := st.UpdateBatch(batch); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	if len(errs) > 0 {
+		return clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+func (ec *execCollector) run(cmd execCommand) ([]metric.Metric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ec.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "sh", "-c", cmd.command)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("timed out after "+ec.timeout.String()))
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if len(msg) > 200 {
+				msg = msg[:200]
+			}
+			return nil, clog.ToLog(clog.FuncName(), errors.New(err.Error()+": "+msg))
+		}
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	batch, err := parseExecOutput(stdout.Bytes())
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return batch, nil
+}
+
+// parseExecOutput accepts either JSON metric objects (a single object, an
+// array or a stream of objects) or plain "name type value" lines.
+func parseExecOutput(out []byte) ([]metric.Metric, error) {
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	var batch []metric.Metric
+	var err error
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		batch, err = parseExecJSON(trimmed)
+	} else {
+		batch, err = parseExecLines(trimmed)
+	}
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return batch, nil
+}
+
+func parseExecJSON(out []byte) ([]metric.Metric, error) {
+	if out[0] == '[' {
+		batch := []metric.Metric{}
+		if err := json.Unmarshal(out, &batch); err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		for i := range batch {
+			if err := validateExecMetric(&batch[i]); err != nil {
+				return nil, clog.ToLog(clog.FuncName(), err)
+			}
+		}
+		return batch, nil
+	}
+	batch := []metric.Metric{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		m := metric.Metric{}
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		if err := validateExecMetric(&m); err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		batch = append(batch, m)
+	}
+	return batch, nil
+}
+
+func validateExecMetric(m *metric.Metric) error {
+	m.Hash = ""
+	if m.ID == "" {
+		return clog.ToLog(clog.FuncName(), errors.New("metric without id"))
+	}
+	switch m.MType {
+	case Gauge:
+		if m.Value == nil {
+			return clog.ToLog(clog.FuncName(), errors.New("gauge <"+m.ID+"> without value"))
+		}
+		m.Delta = nil
+	case Counter:
+		if m.Delta == nil {
+			return clog.ToLog(clog.FuncName(), errors.New("counter <"+m.ID+"> without delta"))
+		}
+		m.Value = nil
+	default:
+		return clog.ToLog(clog.FuncName(), errors.New("unsupported metric type <"+m.MType+">"))
+	}
+	return nil
+}
+
+func parseExecLines(out []byte) ([]metric.Metric, error) {
+	batch := []metric.Metric{}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("line "+strconv.Itoa(line)+": expected <name type value>"))
+		}
+		m := metric.Metric{
+			ID:    fields[0],
+			MType: fields[1],
+		}
+		switch m.MType {
+		case Gauge:
+			val, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, clog.ToLog(clog.FuncName(), errors.New("line "+strconv.Itoa(line)+": "+err.Error()))
+			}
+			m.Value = &val
+		case Counter:
+			del, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, clog.ToLog(clog.FuncName(), errors.New("line "+strconv.Itoa(line)+": "+err.Error()))
+			}
+			m.Delta = &del
+		default:
+			return nil, clog.ToLog(clog.FuncName(), errors.New("line "+strconv.Itoa(line)+": unsupported metric type <"+m.MType+">"))
+		}
+		batch = append(batch, m)
+	}
+	if err := s.Err(); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return batch, nil
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseExecOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		wantIDs []string
+		wantErr bool
+	}{
+		{"lines", "# comment\nQueueLen gauge 3.5\nJobs counter 2\n", []string{"QueueLen", "Jobs"}, false},
+		{"json stream", `{"id":"A","type":"gauge","value":1}` + "\n" + `{"id":"B","type":"counter","delta":1}`, []string{"A", "B"}, false},
+		{"json array", `[{"id":"A","type":"gauge","value":1}]`, []string{"A"}, false},
+		{"empty", "  \n", nil, false},
+		{"bad type", "A histogram 1", nil, true},
+		{"bad value", "A counter 1.5", nil, true},
+		{"missing value", `{"id":"A","type":"gauge"}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := parseExecOutput([]byte(tt.out))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			var ids []string
+			for i := range batch {
+				ids = append(ids, batch[i].ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+func Test_execCollector(t *testing.T) {
+	ec, err := newExecCollector([]string{"ok=echo Jobs counter 2", "fail=exit 3"}, 0)
+	assert.NoError(t, err)
+	st := internalstorage.New("", "")
+	assert.Error(t, ec.Collect(st))
+
+	m, err := st.GetMetric("Jobs")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), *m.Delta)
+	m, err = st.GetMetric("ExecFailures_fail")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), *m.Delta)
+}
@@ -0,0 +1,279 @@
+package agent
+
+import (
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const (
+	FanoutReplicate = "replicate"
+	FanoutFailover  = "failover"
+	FanoutHash      = "hash"
+)
+
+// destination keeps the retry state of one server independently of the
+// others. While a destination backs off it is skipped by every report.
+type destination struct {
+	addr string
+
+	mu       sync.Mutex
+	failures int
+	retryAt  time.Time
+}
+
+type sendFunc func(dest *destination, batch []metric.Metric) error
+
+func (agn *AgentConfig) initDestinations() error {
+	agn.dests = nil
+	for _, addr := range strings.Split(agn.Cfg.SrvAddr, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		agn.dests = append(agn.dests, &destination{addr: addr})
+	}
+	switch agn.Cfg.FanoutPolicy {
+	case "":
+		agn.Cfg.FanoutPolicy = FanoutReplicate
+	case FanoutReplicate, FanoutFailover, FanoutHash:
+	default:
+		return clog.ToLog(clog.FuncName(), errors.New("unsupported fanout policy <"+agn.Cfg.FanoutPolicy+">"))
+	}
+	agn.acked = map[*destination]map[string]int64{}
+	for i := range agn.dests {
+		agn.acked[agn.dests[i]] = map[string]int64{}
+	}
+	return nil
+}
+
+// canSpool reports whether a failed report can be moved to the spool as a
+// whole, which holds when a report is acknowledged by a single server.
+func (agn *AgentConfig) canSpool() bool {
+	return len(agn.dests) == 1 || agn.Cfg.FanoutPolicy == FanoutFailover
+}
+
+func (d *destination) available() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return !time.Now().Before(d.retryAt)
+}
+
+func (agn *AgentConfig) markResult(d *destination, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		d.failures = 0
+		d.retryAt = time.Time{}
+		return
+	}
+	d.retryAt = time.Now().Add(backoff(d.failures, agn.Cfg.RetryMinDelay, agn.Cfg.RetryMaxDelay, agn.Cfg.RetryJitter))
+	d.failures++
+}
+
+func (agn *AgentConfig) try(d *destination, batch []metric.Metric, send sendFunc) error {
+	if !d.available() {
+		return clog.ToLog(clog.FuncName(), errors.New("destination <"+d.addr+"> is backing off"))
+	}
+	err := send(d, batch)
+	agn.markResult(d, err)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+// deliver sends the batch according to the fanout policy and settles the
+// counters that every required destination acknowledged. When nothing could
+// be delivered and a single server owns the report, it goes to the spool.
+func (agn *AgentConfig) deliver(batch []metric.Metric, send sendFunc) error {
+	if len(agn.dests) == 0 {
+		return clog.ToLog(clog.FuncName(), errors.New("no destinations configured"))
+	}
+	var delivered bool
+	var err error
+	switch agn.Cfg.FanoutPolicy {
+	case FanoutFailover:
+		delivered, err = agn.deliverFailover(batch, send)
+	case FanoutHash:
+		delivered, err = agn.deliverHash(batch, send)
+	default:
+		delivered, err = agn.deliverReplicate(batch, send)
+	}
+	if err == nil {
+		return nil
+	}
+	if delivered || agn.spool == nil || !agn.canSpool() {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	clog.Warn("delivery failed, spooling batch", clog.F("metrics", len(batch)), clog.Err(clog.ToLog(clog.FuncName(), err)))
+	if err := agn.spoolMetrics(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (agn *AgentConfig) deliverFailover(batch []metric.Metric, send sendFunc) (bool, error) {
+	var errs []string
+	for _, d := range agn.dests {
+		if err := agn.try(d, batch, send); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := agn.subtractCounters(batch); err != nil {
+			return true, clog.ToLog(clog.FuncName(), err)
+		}
+		return true, nil
+	}
+	return false, clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+}
+
+func (agn *AgentConfig) deliverHash(batch []metric.Metric, send sendFunc) (bool, error) {
+	groups := make([][]metric.Metric, len(agn.dests))
+	for i := range batch {
+		h := fnv.New32a()
+		h.Write([]byte(batch[i].ID))
+		n := int(h.Sum32() % uint32(len(agn.dests)))
+		groups[n] = append(groups[n], batch[i])
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []string
+	delivered := false
+	for i := range groups {
+		if len(groups[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(d *destination, group []metric.Metric) {
+			defer wg.Done()
+			err := agn.try(d, group, send)
+			if err == nil {
+				err = agn.subtractCounters(group)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			delivered = true
+		}(agn.dests[i], groups[i])
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return delivered, clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+	}
+	return delivered, nil
+}
+
+// deliverReplicate sends the batch to every destination. Each destination
+// only receives the part of a counter it has not acknowledged yet, and the
+// storage is decreased by the amount acknowledged by all of them.
+func (agn *AgentConfig) deliverReplicate(batch []metric.Metric, send sendFunc) (bool, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []string
+	delivered := false
+	for _, d := range agn.dests {
+		wg.Add(1)
+		go func(d *destination) {
+			defer wg.Done()
+			pending := agn.pendingFor(d, batch)
+			var err error
+			if len(pending) > 0 {
+				err = agn.try(d, pending, send)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			agn.acknowledge(d, batch)
+			delivered = true
+		}(d)
+	}
+	wg.Wait()
+
+	if err := agn.settle(batch); err != nil {
+		return delivered, clog.ToLog(clog.FuncName(), err)
+	}
+	if len(errs) > 0 {
+		return delivered, clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+	}
+	return delivered, nil
+}
+
+func (agn *AgentConfig) pendingFor(d *destination, batch []metric.Metric) []metric.Metric {
+	agn.ackedMu.Lock()
+	defer agn.ackedMu.Unlock()
+
+	pending := make([]metric.Metric, 0, len(batch))
+	for i := range batch {
+		m := batch[i]
+		if m.MType == Counter && m.Delta != nil {
+			del := *m.Delta - agn.acked[d][m.ID]
+			if del == 0 {
+				continue
+			}
+			m.Delta = &del
+		}
+		pending = append(pending, m)
+	}
+	return pending
+}
+
+func (agn *AgentConfig) acknowledge(d *destination, batch []metric.Metric) {
+	agn.ackedMu.Lock()
+	defer agn.ackedMu.Unlock()
+
+	for i := range batch {
+		if batch[i].MType == Counter && batch[i].Delta != nil {
+			agn.acked[d][batch[i].ID] = *batch[i].Delta
+		}
+	}
+}
+
+func (agn *AgentConfig) settle(batch []metric.Metric) error {
+	agn.ackedMu.Lock()
+	defer agn.ackedMu.Unlock()
+
+	settled := []metric.Metric{}
+	for i := range batch {
+		if batch[i].MType != Counter || batch[i].Delta == nil {
+			continue
+		}
+		id := batch[i].ID
+		var least int64
+		for j, d := range agn.dests {
+			if a := agn.acked[d][id]; j == 0 || a < least {
+				least = a
+			}
+		}
+		if least == 0 {
+			continue
+		}
+		for _, d := range agn.dests {
+			agn.acked[d][id] -= least
+		}
+		del := least
+		settled = append(settled, metric.Metric{
+			ID:    id,
+			MType: Counter,
+			Delta: &del,
+		})
+	}
+	if err := agn.subtractCounters(settled); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
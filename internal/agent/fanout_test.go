@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/custom"
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeServer struct {
+	sync.Mutex
+	*httptest.Server
+	down     bool
+	received int64
+}
+
+func newFakeServer() *fakeServer {
+	fs := &fakeServer{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.Lock()
+		defer fs.Unlock()
+		if fs.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Split(custom.CustomSplit())
+		for scanner.Scan() {
+			m := metric.Metric{}
+			if err := json.Unmarshal(scanner.Bytes(), &m); err == nil && m.Delta != nil {
+				fs.received += *m.Delta
+			}
+		}
+	}))
+	return fs
+}
+
+func (fs *fakeServer) setDown(down bool) {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.down = down
+}
+
+func Test_deliverReplicate(t *testing.T) {
+	a, b := newFakeServer(), newFakeServer()
+	defer a.Close()
+	defer b.Close()
+
+	agn := AgentConfig{
+		Storage: internalstorage.New("", ""),
+		Cfg: EnvConfig{
+			SrvAddr:      strings.TrimPrefix(a.URL, HTTPStr) + "," + strings.TrimPrefix(b.URL, HTTPStr),
+			FanoutPolicy: FanoutReplicate,
+		},
+	}
+	assert.NoError(t, agn.initDestinations())
+	inc := func(n int64) {
+		assert.NoError(t, agn.Storage.UpdateMetric(metric.Metric{ID: "PollCount", MType: Counter, Delta: &n}))
+	}
+
+	inc(3)
+	b.setDown(true)
+	assert.Error(t, agn.sendBatch())
+	m, _ := agn.Storage.GetMetric("PollCount")
+	assert.Equal(t, int64(3), *m.Delta)
+
+	inc(2)
+	b.setDown(false)
+	agn.markResult(agn.dests[1], nil)
+	assert.NoError(t, agn.sendBatch())
+
+	assert.Equal(t, int64(5), a.received)
+	assert.Equal(t, int64(5), b.received)
+	m, _ = agn.Storage.GetMetric("PollCount")
+	assert.Equal(t, int64(0), *m.Delta)
+}
@@ -0,0 +1,415 @@
+//go:build linux
+
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+var hostGauges = [...]string{
+	"LoadAverage1",
+	"LoadAverage5",
+	"LoadAverage15",
+	"TotalMemory",
+	"FreeMemory",
+	"AvailableMemory",
+}
+
+func init() {
+	RegisterCollector("host", func(cfg EnvConfig) (Collector, error) {
+		return newHostCollector(cfg.ProcPath, cfg.SysPath), nil
+	})
+}
+
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+type hostCollector struct {
+	proc string
+	sys  string
+
+	mu      sync.Mutex
+	prevCPU map[string]cpuTimes
+	counts  deltas
+}
+
+func newHostCollector(proc, sys string) *hostCollector {
+	if proc == "" {
+		proc = "/proc"
+	}
+	if sys == "" {
+		sys = "/sys"
+	}
+	return &hostCollector{
+		proc:    proc,
+		sys:     sys,
+		prevCPU: map[string]cpuTimes{},
+	}
+}
+
+func (hc *hostCollector) Describe() []metric.Metric {
+	return describe(hostGauges[:], Gauge)
+}
+
+func (hc *hostCollector) Collect(st metric.MStorage) error {
+	batch := []metric.Metric{}
+	var errs []string
+	for _, collect := range []func() ([]metric.Metric, error){
+		hc.collectCPU,
+		hc.collectLoad,
+		hc.collectMemory,
+		hc.collectDiskUsage,
+		hc.collectDiskIO,
+		hc.collectNet,
+	} {
+		ms, err := collect()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		batch = append(batch, ms...)
+	}
+	if len(batch) > 0 {
+		if err := st.UpdateBatch(batch); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	if len(errs) > 0 {
+		return clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+func (hc *hostCollector) collectCPU() ([]metric.Metric, error) {
+	file, err := os.Open(filepath.Join(hc.proc, "stat"))
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	defer file.Close()
+	cur, err := parseCPUStat(file)
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	batch := []metric.Metric{}
+	for name, t := range cur {
+		prev, ok := hc.prevCPU[name]
+		hc.prevCPU[name] = t
+		if !ok || t.total <= prev.total {
+			continue
+		}
+		busy := float64((t.total-prev.total)-(t.idle-prev.idle)) / float64(t.total-prev.total)
+		val := 100 * busy
+		id := "CPUutilization"
+		if name != "cpu" {
+			n, err := strconv.Atoi(strings.TrimPrefix(name, "cpu"))
+			if err != nil {
+				continue
+			}
+			id += strconv.Itoa(n + 1)
+		}
+		batch = append(batch, gaugeMetric(id, val))
+	}
+	return batch, nil
+}
+
+// parseCPUStat reads the cpu lines of /proc/stat, counting iowait as idle.
+func parseCPUStat(r io.Reader) (map[string]cpuTimes, error) {
+	res := map[string]cpuTimes{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		var t cpuTimes
+		for i, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, clog.ToLog(clog.FuncName(), err)
+			}
+			// guest and guest_nice are already included in user and nice
+			if i >= 8 {
+				break
+			}
+			t.total += v
+			if i == 3 || i == 4 {
+				t.idle += v
+			}
+		}
+		res[fields[0]] = t
+	}
+	if err := s.Err(); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return res, nil
+}
+
+func (hc *hostCollector) collectLoad() ([]metric.Metric, error) {
+	b, err := os.ReadFile(filepath.Join(hc.proc, "loadavg"))
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 3 {
+		return nil, clog.ToLog(clog.FuncName(), errors.New("unexpected loadavg format"))
+	}
+	batch := []metric.Metric{}
+	for i, id := range hostGauges[:3] {
+		val, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		batch = append(batch, gaugeMetric(id, val))
+	}
+	return batch, nil
+}
+
+func (hc *hostCollector) collectMemory() ([]metric.Metric, error) {
+	file, err := os.Open(filepath.Join(hc.proc, "meminfo"))
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	defer file.Close()
+	info, err := parseKeyValueKB(file)
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	batch := []metric.Metric{}
+	for key, id := range map[string]string{
+		"MemTotal":     "TotalMemory",
+		"MemFree":      "FreeMemory",
+		"MemAvailable": "AvailableMemory",
+	} {
+		if val, ok := info[key]; ok {
+			batch = append(batch, gaugeMetric(id, float64(val)))
+		}
+	}
+	return batch, nil
+}
+
+// parseKeyValueKB reads "Key: value kB" files such as /proc/meminfo and
+// /proc/<pid>/status, returning sizes in bytes.
+func parseKeyValueKB(r io.Reader) (map[string]int64, error) {
+	res := map[string]int64{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, val, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(val)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+		res[key] = v
+	}
+	if err := s.Err(); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return res, nil
+}
+
+// collectDiskUsage reads the mount table of the host. Mount points are host
+// paths: when procfs of the host is mounted elsewhere, e.g. at /host/proc in
+// a container, they are resolved under the parent of that mount point.
+func (hc *hostCollector) collectDiskUsage() ([]metric.Metric, error) {
+	file, err := os.Open(filepath.Join(hc.proc, "mounts"))
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	defer file.Close()
+
+	batch := []metric.Metric{}
+	seen := map[string]bool{}
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "/dev/") || seen[fields[1]] {
+			continue
+		}
+		seen[fields[1]] = true
+		mount := unescapeMount(fields[1])
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(filepath.Join(filepath.Dir(hc.proc), mount), &fs); err != nil {
+			continue
+		}
+		suffix := metricSuffix(mount)
+		total := float64(fs.Blocks) * float64(fs.Bsize)
+		free := float64(fs.Bavail) * float64(fs.Bsize)
+		used := total - float64(fs.Bfree)*float64(fs.Bsize)
+		batch = append(batch,
+			gaugeMetric("DiskTotal_"+suffix, total),
+			gaugeMetric("DiskFree_"+suffix, free),
+			gaugeMetric("DiskUsed_"+suffix, used),
+		)
+	}
+	if err := s.Err(); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return batch, nil
+}
+
+// unescapeMount decodes the octal escapes, such as \040 for a space, that
+// the kernel writes in place of whitespace and backslashes in mount paths.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func (hc *hostCollector) collectDiskIO() ([]metric.Metric, error) {
+	devices := map[string]bool{}
+	entries, err := os.ReadDir(filepath.Join(hc.sys, "block"))
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	for i := range entries {
+		name := entries[i].Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		devices[name] = true
+	}
+
+	file, err := os.Open(filepath.Join(hc.proc, "diskstats"))
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	defer file.Close()
+
+	batch := []metric.Metric{}
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 || !devices[fields[2]] {
+			continue
+		}
+		suffix := metricSuffix(fields[2])
+		// reads, sectors read, writes, sectors written; sectors are 512 bytes
+		for id, col := range map[string]int{
+			"DiskReads_":      3,
+			"DiskReadBytes_":  5,
+			"DiskWrites_":     7,
+			"DiskWriteBytes_": 9,
+		} {
+			v, err := strconv.ParseInt(fields[col], 10, 64)
+			if err != nil {
+				return nil, clog.ToLog(clog.FuncName(), err)
+			}
+			if col == 5 || col == 9 {
+				v *= 512
+			}
+			if m, ok := hc.counts.counter(id+suffix, v); ok {
+				batch = append(batch, m)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return batch, nil
+}
+
+func (hc *hostCollector) collectNet() ([]metric.Metric, error) {
+	file, err := os.Open(filepath.Join(hc.proc, "net", "dev"))
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	defer file.Close()
+	stats, err := parseNetDev(file)
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+
+	batch := []metric.Metric{}
+	for iface, st := range stats {
+		suffix := metricSuffix(iface)
+		for id, v := range map[string]int64{
+			"NetRxBytes_":   st.rxBytes,
+			"NetRxPackets_": st.rxPackets,
+			"NetTxBytes_":   st.txBytes,
+			"NetTxPackets_": st.txPackets,
+		} {
+			if m, ok := hc.counts.counter(id+suffix, v); ok {
+				batch = append(batch, m)
+			}
+		}
+	}
+	return batch, nil
+}
+
+type netStats struct {
+	rxBytes   int64
+	rxPackets int64
+	txBytes   int64
+	txPackets int64
+}
+
+func parseNetDev(r io.Reader) (map[string]netStats, error) {
+	res := map[string]netStats{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		iface, rest, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 10 {
+			continue
+		}
+		var vals [4]int64
+		for i, col := range [...]int{0, 1, 8, 9} {
+			v, err := strconv.ParseInt(fields[col], 10, 64)
+			if err != nil {
+				return nil, clog.ToLog(clog.FuncName(), err)
+			}
+			vals[i] = v
+		}
+		res[strings.TrimSpace(iface)] = netStats{
+			rxBytes:   vals[0],
+			rxPackets: vals[1],
+			txBytes:   vals[2],
+			txPackets: vals[3],
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return res, nil
+}
@@ -0,0 +1,75 @@
+//go:build linux
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseCPUStat(t *testing.T) {
+	stat := "cpu  10 0 5 80 5 0 0 0 0 0\ncpu0 10 0 5 80 5 0 0 0 0 0\nintr 1 2 3\n"
+	res, err := parseCPUStat(strings.NewReader(stat))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]cpuTimes{
+		"cpu":  {idle: 85, total: 100},
+		"cpu0": {idle: 85, total: 100},
+	}, res)
+}
+
+func Test_parseNetDev(t *testing.T) {
+	dev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  eth0: 1000      10    0    0    0     0          0         0     2000      20    0    0    0     0       0          0
+`
+	res, err := parseNetDev(strings.NewReader(dev))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]netStats{
+		"eth0": {rxBytes: 1000, rxPackets: 10, txBytes: 2000, txPackets: 20},
+	}, res)
+}
+
+func Test_unescapeMount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/", "/"},
+		{"/mnt/data", "/mnt/data"},
+		{`/mnt/my\040disk`, "/mnt/my disk"},
+		{`/mnt/tab\011and\134slash`, "/mnt/tab\tand\\slash"},
+		{`/mnt/bad\04`, `/mnt/bad\04`},
+		{`/mnt/bad\999`, `/mnt/bad\999`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.want, unescapeMount(tt.in))
+		})
+	}
+}
+
+func Test_collectDiskUsage(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "mnt", "my disk"), 0o755))
+	mounts := `/dev/sda1 /mnt/my\040disk ext4 rw 0 0
+/dev/sda2 /mnt/missing ext4 rw 0 0
+proc /proc proc rw 0 0
+`
+	require.NoError(t, os.WriteFile(filepath.Join(root, "proc", "mounts"), []byte(mounts), 0o644))
+
+	hc := newHostCollector(filepath.Join(root, "proc"), "")
+	batch, err := hc.collectDiskUsage()
+	require.NoError(t, err)
+	ids := []string{}
+	for _, m := range batch {
+		ids = append(ids, m.ID)
+		assert.NotNil(t, m.Value, m.ID)
+	}
+	assert.Equal(t, []string{"DiskTotal_mnt_my_disk", "DiskFree_mnt_my_disk", "DiskUsed_mnt_my_disk"}, ids)
+}
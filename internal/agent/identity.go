@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+// Version is reported to the server with every request, it is meant to be
+// set at build time with -ldflags "-X github.com/dcaiman/YP_GO/internal/agent.Version=...".
+var Version = "dev"
+
+var machineIDFiles = [...]string{
+	"/etc/machine-id",
+	"/var/lib/dbus/machine-id",
+}
+
+func (agn *AgentConfig) initIdentity() error {
+	if agn.Cfg.AgentID == "" {
+		agn.Cfg.AgentID = defaultAgentID()
+	}
+	labels, err := parseLabels(agn.Cfg.Labels)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	agn.labels = labels
+	return nil
+}
+
+// defaultAgentID prefers the machine id, which survives hostname changes,
+// and falls back to the hostname.
+func defaultAgentID() string {
+	for _, path := range machineIDFiles {
+		if b, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(b)); id != "" {
+				return id
+			}
+		}
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "unknown"
+}
+
+func parseLabels(entries []string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	labels := map[string]string{}
+	for i := range entries {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(entry, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("invalid label <"+entry+">, expected key=value"))
+		}
+		labels[k] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
+
+func (agn *AgentConfig) identityHeader() http.Header {
+	h := http.Header{}
+	if agn.Cfg.AgentID != "" {
+		h.Set(metric.AgentIDHeader, agn.Cfg.AgentID)
+		h.Set(metric.AgentVersionHeader, Version)
+	}
+	return h
+}
+
+func (agn *AgentConfig) withLabels(batch []metric.Metric) []metric.Metric {
+	if len(agn.labels) == 0 {
+		return batch
+	}
+	labeled := make([]metric.Metric, len(batch))
+	for i := range batch {
+		labeled[i] = batch[i]
+		labeled[i].Labels = agn.labels
+	}
+	return labeled
+}
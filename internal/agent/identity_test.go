@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"trimmed", []string{" dc = eu ", "host=h1"}, map[string]string{"dc": "eu", "host": "h1"}, false},
+		{"empty entries skipped", []string{"", "dc=eu", " "}, map[string]string{"dc": "eu"}, false},
+		{"empty value", []string{"team="}, map[string]string{"team": ""}, false},
+		{"value with equals", []string{"expr=a=b"}, map[string]string{"expr": "a=b"}, false},
+		{"missing equals", []string{"dc"}, nil, true},
+		{"empty key", []string{"=eu"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabels(tt.entries)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
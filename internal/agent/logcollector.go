@@ -0,0 +1,274 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+// maxLogRead bounds how much of a file is consumed per collect so a burst of
+// log lines cannot stall the agent.
+const maxLogRead = 4 << 20
+
+func init() {
+	RegisterCollector("logtail", func(cfg EnvConfig) (Collector, error) {
+		return newLogCollector(cfg.LogRulesFile)
+	})
+}
+
+// LogRule describes how lines of a log file turn into a metric. Name and
+// Value are templates that may reference capture groups as $1 or ${group}.
+type LogRule struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Value   string `json:"value,omitempty"`
+
+	re *regexp.Regexp
+}
+
+type logTailer struct {
+	path    string
+	rules   []*LogRule
+	file    *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+type logCollector struct {
+	sync.Mutex
+	tailers []*logTailer
+}
+
+func newLogCollector(rulesFile string) (*logCollector, error) {
+	if rulesFile == "" {
+		return nil, clog.ToLog(clog.FuncName(), errors.New("no log rules, set LOG_RULES_FILE"))
+	}
+	b, err := os.ReadFile(rulesFile)
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	rules := []*LogRule{}
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	lc := &logCollector{}
+	byPath := map[string]*logTailer{}
+	for i, r := range rules {
+		if err := r.compile(); err != nil {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("rule "+strconv.Itoa(i)+": "+err.Error()))
+		}
+		t, ok := byPath[r.Path]
+		if !ok {
+			t = &logTailer{path: r.Path}
+			byPath[r.Path] = t
+			lc.tailers = append(lc.tailers, t)
+		}
+		t.rules = append(t.rules, r)
+	}
+	return lc, nil
+}
+
+func (r *LogRule) compile() error {
+	if r.Path == "" || r.Pattern == "" || r.Name == "" {
+		return clog.ToLog(clog.FuncName(), errors.New("path, pattern and name are required"))
+	}
+	switch r.Type {
+	case Counter:
+	case Gauge:
+		if r.Value == "" {
+			return clog.ToLog(clog.FuncName(), errors.New("gauge rule <"+r.Name+"> needs a value template"))
+		}
+	default:
+		return clog.ToLog(clog.FuncName(), errors.New("unsupported metric type <"+r.Type+">"))
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	r.re = re
+	return nil
+}
+
+func (lc *logCollector) Describe() []metric.Metric {
+	return nil
+}
+
+func (lc *logCollector) Collect(st metric.MStorage) error {
+	lc.Lock()
+	defer lc.Unlock()
+
+	counts := map[string]int64{}
+	gauges := map[string]float64{}
+	var errs []string
+	for _, t := range lc.tailers {
+		lines, err := t.readLines()
+		if err != nil {
+			errs = append(errs, t.path+": "+err.Error())
+		}
+		for _, line := range lines {
+			for _, r := range t.rules {
+				if err := r.apply(line, counts, gauges); err != nil {
+					errs = append(errs, t.path+": "+err.Error())
+				}
+			}
+		}
+	}
+
+	batch := []metric.Metric{}
+	for id, del := range counts {
+		del := del
+		batch = append(batch, metric.Metric{
+			ID:    id,
+			MType: Counter,
+			Delta: &del,
+		})
+	}
+	for id, val := range gauges {
+		batch = append(batch, gaugeMetric(id, val))
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+	if len(batch) > 0 {
+		if err := st.UpdateBatch(batch); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	if len(errs) > 0 {
+		return clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+func (r *LogRule) apply(line []byte, counts map[string]int64, gauges map[string]float64) error {
+	idx := r.re.FindSubmatchIndex(line)
+	if idx == nil {
+		return nil
+	}
+	id := string(r.re.Expand(nil, []byte(r.Name), line, idx))
+	if id == "" {
+		return nil
+	}
+	val := ""
+	if r.Value != "" {
+		val = strings.TrimSpace(string(r.re.Expand(nil, []byte(r.Value), line, idx)))
+	}
+	switch r.Type {
+	case Counter:
+		var del int64 = 1
+		if val != "" {
+			v, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return clog.ToLog(clog.FuncName(), err)
+			}
+			del = v
+		}
+		counts[id] += del
+	case Gauge:
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		gauges[id] = v
+	}
+	return nil
+}
+
+// readLines returns the complete lines appended since the previous call. A
+// newly opened file is read from its end; after rotation the old file is
+// drained first and the new one is read from the beginning.
+func (t *logTailer) readLines() ([][]byte, error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		if t.file != nil && os.IsNotExist(err) {
+			// rotated away and not recreated yet, keep reading the old file
+			return t.read()
+		}
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+
+	if t.file == nil {
+		if err := t.open(info, true); err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		return nil, nil
+	}
+
+	if !os.SameFile(t.info, info) {
+		lines, err := t.read()
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		if len(t.partial) > 0 {
+			lines = append(lines, t.partial)
+			t.partial = nil
+		}
+		t.file.Close()
+		if err := t.open(info, false); err != nil {
+			return lines, clog.ToLog(clog.FuncName(), err)
+		}
+		more, err := t.read()
+		return append(lines, more...), err
+	}
+
+	if info.Size() < t.offset {
+		// truncated in place
+		t.offset = 0
+		t.partial = nil
+	}
+	return t.read()
+}
+
+func (t *logTailer) open(info os.FileInfo, fromEnd bool) error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	t.file = file
+	t.info = info
+	t.offset = 0
+	if fromEnd {
+		t.offset = info.Size()
+	}
+	return nil
+}
+
+func (t *logTailer) read() ([][]byte, error) {
+	buf := make([]byte, 64<<10)
+	data := t.partial
+	for read := 0; read < maxLogRead; {
+		n, err := t.file.ReadAt(buf, t.offset)
+		data = append(data, buf[:n]...)
+		t.offset += int64(n)
+		read += n
+		if errors.Is(err, io.EOF) || n == 0 {
+			break
+		}
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+	}
+
+	lines := [][]byte{}
+	for {
+		n := bytes.IndexByte(data, '\n')
+		if n < 0 {
+			break
+		}
+		lines = append(lines, bytes.TrimRight(data[:n], "\r"))
+		data = data[n+1:]
+	}
+	t.partial = append([]byte(nil), data...)
+	return lines, nil
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_logCollector(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	rulesPath := filepath.Join(dir, "rules.json")
+	assert.NoError(t, os.WriteFile(logPath, []byte("old ERROR line\n"), 0666))
+	assert.NoError(t, os.WriteFile(rulesPath, []byte(`[
+		{"path": "`+logPath+`", "pattern": "(?P<level>ERROR|WARN)", "type": "counter", "name": "Log${level}"},
+		{"path": "`+logPath+`", "pattern": "latency=(\\d+)", "type": "gauge", "name": "Latency", "value": "$1"}
+	]`), 0666))
+
+	lc, err := newLogCollector(rulesPath)
+	assert.NoError(t, err)
+	st := internalstorage.New("", "")
+	appendLog := func(s string) {
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+		assert.NoError(t, err)
+		_, err = f.WriteString(s)
+		assert.NoError(t, err)
+		f.Close()
+	}
+
+	assert.NoError(t, lc.Collect(st))
+	appendLog("ERROR a\nWARN b\nlatency=15\nERROR partial")
+	assert.NoError(t, lc.Collect(st))
+
+	assert.NoError(t, os.Rename(logPath, logPath+".1"))
+	appendLog("ERROR c\nlatency=20\n")
+	assert.NoError(t, lc.Collect(st))
+
+	m, err := st.GetMetric("LogERROR")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), *m.Delta)
+	m, err = st.GetMetric("LogWARN")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), *m.Delta)
+	m, err = st.GetMetric("Latency")
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, *m.Value)
+}
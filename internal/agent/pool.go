@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+)
+
+type sendJob struct {
+	name   string
+	done   *sync.WaitGroup
+	failed *int64
+}
+
+func (agn *AgentConfig) startWorkers() {
+	n := agn.Cfg.RateLimit
+	if n < 1 {
+		n = 1
+	}
+	agn.jobs = make(chan sendJob, n)
+	for i := 0; i < n; i++ {
+		go agn.worker()
+	}
+}
+
+func (agn *AgentConfig) worker() {
+	for job := range agn.jobs {
+		atomic.AddInt64(&agn.inFlight, 1)
+		if err := agn.sendMetric(job.name); err != nil {
+			atomic.AddInt64(job.failed, 1)
+			clog.Error("send failed", clog.F("metric", job.name), clog.Err(clog.ToLog(clog.FuncName(), err)))
+		}
+		atomic.AddInt64(&agn.inFlight, -1)
+		job.done.Done()
+	}
+}
+
+func (agn *AgentConfig) InFlight() int64 {
+	return atomic.LoadInt64(&agn.inFlight)
+}
+
+func (agn *AgentConfig) sendAll(names []string) error {
+	var wg sync.WaitGroup
+	var failed int64
+	for i := range names {
+		wg.Add(1)
+		agn.jobs <- sendJob{
+			name:   names[i],
+			done:   &wg,
+			failed: &failed,
+		}
+	}
+	wg.Wait()
+	if failed > 0 {
+		return clog.ToLog(clog.FuncName(), errors.New("failed to send "+strconv.FormatInt(failed, 10)+" of "+strconv.Itoa(len(names))+" metrics"))
+	}
+	return nil
+}
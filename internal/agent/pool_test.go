@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingServer holds every request until release is closed and records
+// the highest number of requests served at once.
+type blockingServer struct {
+	*httptest.Server
+	release chan struct{}
+	active  int64
+	peak    int64
+	hits    int64
+}
+
+func newBlockingServer(fail func(r *http.Request) bool) *blockingServer {
+	bs := &blockingServer{release: make(chan struct{})}
+	bs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&bs.active, 1)
+		defer atomic.AddInt64(&bs.active, -1)
+		atomic.AddInt64(&bs.hits, 1)
+		for {
+			peak := atomic.LoadInt64(&bs.peak)
+			if n <= peak || atomic.CompareAndSwapInt64(&bs.peak, peak, n) {
+				break
+			}
+		}
+		<-bs.release
+		if fail != nil && fail(r) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return bs
+}
+
+func newPoolAgent(t *testing.T, addr string, rateLimit, metrics int) *AgentConfig {
+	agn := &AgentConfig{
+		Storage: internalstorage.New("", ""),
+		Cfg: EnvConfig{
+			SrvAddr:   strings.TrimPrefix(addr, HTTPStr),
+			CType:     JSONCT,
+			RateLimit: rateLimit,
+		},
+	}
+	require.NoError(t, agn.initDestinations())
+	agn.startWorkers()
+	for i := 0; i < metrics; i++ {
+		val := float64(i)
+		require.NoError(t, agn.Storage.UpdateMetric(metric.Metric{ID: "G" + string(rune('a'+i)), MType: Gauge, Value: &val}))
+	}
+	return agn
+}
+
+func Test_sendAll(t *testing.T) {
+	bs := newBlockingServer(nil)
+	defer bs.Close()
+	agn := newPoolAgent(t, bs.URL, 2, 6)
+
+	done := make(chan error)
+	go func() {
+		done <- agn.sendAll([]string{"Ga", "Gb", "Gc", "Gd", "Ge", "Gf"})
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&bs.active) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), agn.InFlight())
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&bs.active), "the other jobs wait for a free worker")
+	close(bs.release)
+	require.NoError(t, <-done)
+
+	assert.Equal(t, int64(6), atomic.LoadInt64(&bs.hits))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&bs.peak), "no more requests than workers")
+	assert.Equal(t, int64(0), agn.InFlight())
+}
+
+func Test_sendAllFailures(t *testing.T) {
+	bs := newBlockingServer(func(r *http.Request) bool { return true })
+	close(bs.release)
+	defer bs.Close()
+	agn := newPoolAgent(t, bs.URL, 3, 3)
+
+	err := agn.sendAll([]string{"Ga", "Gb", "Gc", "Missing"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to send 4 of 4 metrics")
+	assert.Equal(t, int64(0), agn.InFlight())
+}
+
+func Test_startReportSkipsOverlap(t *testing.T) {
+	bs := newBlockingServer(nil)
+	defer bs.Close()
+	agn := newPoolAgent(t, bs.URL, 1, 1)
+
+	require.True(t, agn.startReport())
+	assert.Eventually(t, func() bool { return agn.InFlight() == 1 }, time.Second, time.Millisecond)
+	var wg sync.WaitGroup
+	var started int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if agn.startReport() {
+				atomic.AddInt64(&started, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(0), started, "a report in progress is not overlapped")
+
+	close(bs.release)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&agn.reporting) == 0 }, time.Second, time.Millisecond)
+	assert.True(t, agn.startReport())
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&agn.reporting) == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&bs.hits))
+}
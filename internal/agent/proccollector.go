@@ -0,0 +1,312 @@
+//go:build linux
+
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+// userHZ is the unit of the time fields in /proc/<pid>/stat, which the
+// kernel exports as 100 on every mainstream architecture.
+const userHZ = 100
+
+func init() {
+	RegisterCollector("process", func(cfg EnvConfig) (Collector, error) {
+		return newProcCollector(cfg.ProcPath, cfg.SysPath, cfg.ProcWatch)
+	})
+}
+
+type procTarget struct {
+	label   string
+	kind    string
+	value   string
+	pattern *regexp.Regexp
+}
+
+type procCollector struct {
+	proc    string
+	sys     string
+	targets []procTarget
+}
+
+// newProcCollector accepts targets in the form [label=]kind:value where kind
+// is pidfile, name (a regular expression over the command line) or cgroup
+// (a cgroup v2 path relative to the cgroup mount).
+func newProcCollector(proc, sys string, watch []string) (*procCollector, error) {
+	if proc == "" {
+		proc = "/proc"
+	}
+	if sys == "" {
+		sys = "/sys"
+	}
+	pc := &procCollector{
+		proc: proc,
+		sys:  sys,
+	}
+	for i := range watch {
+		entry := strings.TrimSpace(watch[i])
+		if entry == "" {
+			continue
+		}
+		t, err := parseProcTarget(entry)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		pc.targets = append(pc.targets, t)
+	}
+	if len(pc.targets) == 0 {
+		return nil, clog.ToLog(clog.FuncName(), errors.New("no processes to watch, set PROC_WATCH"))
+	}
+	return pc, nil
+}
+
+func parseProcTarget(entry string) (procTarget, error) {
+	t := procTarget{}
+	spec := entry
+	if label, rest, ok := strings.Cut(entry, "="); ok && !strings.Contains(label, ":") {
+		t.label = label
+		spec = rest
+	}
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok || value == "" {
+		return t, clog.ToLog(clog.FuncName(), errors.New("invalid process target <"+entry+">, expected [label=]kind:value"))
+	}
+	t.kind = kind
+	t.value = value
+	switch kind {
+	case "pidfile", "cgroup":
+	case "name":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return t, clog.ToLog(clog.FuncName(), err)
+		}
+		t.pattern = re
+	default:
+		return t, clog.ToLog(clog.FuncName(), errors.New("unsupported process target kind <"+kind+">"))
+	}
+	if t.label == "" {
+		t.label = value
+		if kind == "pidfile" {
+			t.label = strings.TrimSuffix(filepath.Base(value), filepath.Ext(value))
+		}
+	}
+	t.label = metricSuffix(t.label)
+	return t, nil
+}
+
+func (pc *procCollector) Describe() []metric.Metric {
+	ms := []metric.Metric{}
+	for i := range pc.targets {
+		for _, name := range procGauges {
+			ms = append(ms, metric.Metric{
+				ID:    name + "_" + pc.targets[i].label,
+				MType: Gauge,
+			})
+		}
+	}
+	return ms
+}
+
+var procGauges = [...]string{
+	"ProcessCount",
+	"ProcessRSS",
+	"ProcessCPUSeconds",
+	"ProcessOpenFDs",
+	"ProcessThreads",
+	"ProcessUptime",
+}
+
+type procStats struct {
+	rss     float64
+	cpu     float64
+	fds     float64
+	threads float64
+	start   float64
+}
+
+func (pc *procCollector) Collect(st metric.MStorage) error {
+	uptime, err := pc.systemUptime()
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+
+	batch := []metric.Metric{}
+	var errs []string
+	for i := range pc.targets {
+		t := pc.targets[i]
+		pids, err := pc.findPids(t)
+		if err != nil {
+			errs = append(errs, t.label+": "+err.Error())
+		}
+		var sum procStats
+		var count, oldest float64
+		for _, pid := range pids {
+			ps, err := pc.readProc(pid)
+			if err != nil {
+				// the process may have exited between listing and reading
+				continue
+			}
+			count++
+			sum.rss += ps.rss
+			sum.cpu += ps.cpu
+			sum.fds += ps.fds
+			sum.threads += ps.threads
+			if age := uptime - ps.start; age > oldest {
+				oldest = age
+			}
+		}
+		batch = append(batch,
+			gaugeMetric("ProcessCount_"+t.label, count),
+			gaugeMetric("ProcessRSS_"+t.label, sum.rss),
+			gaugeMetric("ProcessCPUSeconds_"+t.label, sum.cpu),
+			gaugeMetric("ProcessOpenFDs_"+t.label, sum.fds),
+			gaugeMetric("ProcessThreads_"+t.label, sum.threads),
+			gaugeMetric("ProcessUptime_"+t.label, oldest),
+		)
+	}
+	if err := st.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if len(errs) > 0 {
+		return clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+	}
+	return nil
+}
+
+func (pc *procCollector) findPids(t procTarget) ([]int, error) {
+	switch t.kind {
+	case "pidfile":
+		b, err := os.ReadFile(t.value)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		return []int{pid}, nil
+	case "cgroup":
+		b, err := os.ReadFile(filepath.Join(pc.sys, "fs", "cgroup", t.value, "cgroup.procs"))
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		pids := []int{}
+		for _, f := range strings.Fields(string(b)) {
+			if pid, err := strconv.Atoi(f); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+		return pids, nil
+	case "name":
+		entries, err := os.ReadDir(pc.proc)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		self := os.Getpid()
+		pids := []int{}
+		for i := range entries {
+			pid, err := strconv.Atoi(entries[i].Name())
+			if err != nil || pid == self {
+				continue
+			}
+			if t.pattern.MatchString(pc.commandLine(pid)) {
+				pids = append(pids, pid)
+			}
+		}
+		return pids, nil
+	}
+	return nil, clog.ToLog(clog.FuncName(), errors.New("unsupported process target kind <"+t.kind+">"))
+}
+
+func (pc *procCollector) commandLine(pid int) string {
+	dir := filepath.Join(pc.proc, strconv.Itoa(pid))
+	b, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err == nil && len(b) > 0 {
+		return strings.TrimSpace(strings.ReplaceAll(string(b), "\x00", " "))
+	}
+	b, err = os.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (pc *procCollector) readProc(pid int) (procStats, error) {
+	dir := filepath.Join(pc.proc, strconv.Itoa(pid))
+	ps := procStats{}
+
+	b, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return ps, clog.ToLog(clog.FuncName(), err)
+	}
+	fields, err := parseProcStat(string(b))
+	if err != nil {
+		return ps, clog.ToLog(clog.FuncName(), err)
+	}
+	// fields start at the state column, so utime (14) is at index 11
+	vals := [4]float64{}
+	for i, col := range [...]int{11, 12, 17, 19} {
+		v, err := strconv.ParseFloat(fields[col], 64)
+		if err != nil {
+			return ps, clog.ToLog(clog.FuncName(), err)
+		}
+		vals[i] = v
+	}
+	ps.cpu = (vals[0] + vals[1]) / userHZ
+	ps.threads = vals[2]
+	ps.start = vals[3] / userHZ
+
+	file, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return ps, clog.ToLog(clog.FuncName(), err)
+	}
+	defer file.Close()
+	status, err := parseKeyValueKB(file)
+	if err != nil {
+		return ps, clog.ToLog(clog.FuncName(), err)
+	}
+	ps.rss = float64(status["VmRSS"])
+
+	if fds, err := os.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		ps.fds = float64(len(fds))
+	}
+	return ps, nil
+}
+
+// parseProcStat returns the fields following the command name, which is
+// enclosed in parentheses and may itself contain spaces.
+func parseProcStat(stat string) ([]string, error) {
+	n := strings.LastIndex(stat, ")")
+	if n < 0 {
+		return nil, clog.ToLog(clog.FuncName(), errors.New("unexpected stat format"))
+	}
+	fields := strings.Fields(stat[n+1:])
+	if len(fields) < 20 {
+		return nil, clog.ToLog(clog.FuncName(), errors.New("unexpected stat format"))
+	}
+	return fields, nil
+}
+
+func (pc *procCollector) systemUptime() (float64, error) {
+	b, err := os.ReadFile(filepath.Join(pc.proc, "uptime"))
+	if err != nil {
+		return 0, clog.ToLog(clog.FuncName(), err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, clog.ToLog(clog.FuncName(), errors.New("unexpected uptime format"))
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, clog.ToLog(clog.FuncName(), err)
+	}
+	return uptime, nil
+}
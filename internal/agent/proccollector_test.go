@@ -0,0 +1,123 @@
+//go:build linux
+
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// procStat builds a /proc/<pid>/stat line; the columns are numbered as in
+// proc(5), starting with the pid as 1.
+func procStat(comm string, utime, stime, threads, start int) string {
+	cols := make([]string, 52)
+	for i := range cols {
+		cols[i] = "0"
+	}
+	cols[0], cols[1], cols[2] = "42", "("+comm+")", "S"
+	cols[13], cols[14] = strconv.Itoa(utime), strconv.Itoa(stime)
+	cols[19], cols[21] = strconv.Itoa(threads), strconv.Itoa(start)
+	return strings.Join(cols, " ") + "\n"
+}
+
+func writeProc(t *testing.T, root string, pid int, cmdline, stat string, rssKB, fds int) {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fd"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.ReplaceAll(cmdline, " ", "\x00")+"\x00"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "status"), []byte("Name:\tx\nVmRSS:\t"+strconv.Itoa(rssKB)+" kB\n"), 0644))
+	for i := 0; i < fds; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(i)), nil, 0644))
+	}
+}
+
+func Test_parseProcTarget(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    procTarget
+		wantErr bool
+	}{
+		{entry: "pidfile:/run/nginx.pid", want: procTarget{label: "nginx", kind: "pidfile", value: "/run/nginx.pid"}},
+		{entry: "db=cgroup:system.slice/postgresql.service", want: procTarget{label: "db", kind: "cgroup", value: "system.slice/postgresql.service"}},
+		{entry: "name:redis-server", want: procTarget{label: "redis_server", kind: "name", value: "redis-server"}},
+		{entry: "web=name:python .*app=prod", want: procTarget{label: "web", kind: "name", value: "python .*app=prod"}},
+		{entry: "pidfile:", wantErr: true},
+		{entry: "nginx", wantErr: true},
+		{entry: "port:80", wantErr: true},
+		{entry: "name:(", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			got, err := parseProcTarget(tt.entry)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			got.pattern = nil
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseProcStat(t *testing.T) {
+	fields, err := parseProcStat(procStat("my (odd) proc", 250, 50, 7, 1200))
+	require.NoError(t, err)
+	assert.Equal(t, "S", fields[0])
+	assert.Equal(t, "250", fields[11])
+	assert.Equal(t, "50", fields[12])
+	assert.Equal(t, "7", fields[17])
+	assert.Equal(t, "1200", fields[19])
+
+	_, err = parseProcStat("42 (short) S 1 2 3")
+	assert.Error(t, err)
+	_, err = parseProcStat("42 no parens")
+	assert.Error(t, err)
+}
+
+func Test_readProc(t *testing.T) {
+	root := t.TempDir()
+	writeProc(t, root, 42, "/usr/bin/app", procStat("my (odd) proc", 250, 50, 7, 1200), 2048, 3)
+	pc := &procCollector{proc: root}
+
+	ps, err := pc.readProc(42)
+	require.NoError(t, err)
+	assert.Equal(t, procStats{rss: 2048 * 1024, cpu: 3, fds: 3, threads: 7, start: 12}, ps)
+
+	_, err = pc.readProc(43)
+	assert.Error(t, err)
+}
+
+func Test_procCollector(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "uptime"), []byte("100.00 50.00\n"), 0644))
+	writeProc(t, root, 10, "python app.py --env prod", procStat("python", 100, 0, 2, 1000), 100, 1)
+	writeProc(t, root, 11, "python app.py --env prod", procStat("python", 200, 100, 3, 5000), 200, 2)
+	writeProc(t, root, 12, "python other.py", procStat("python", 900, 0, 1, 0), 900, 9)
+
+	pc, err := newProcCollector(root, root, []string{"app=name:app\\.py"})
+	require.NoError(t, err)
+	st := internalstorage.New("", "")
+	require.NoError(t, pc.Collect(st))
+
+	want := map[string]float64{
+		"ProcessCount_app":      2,
+		"ProcessRSS_app":        300 * 1024,
+		"ProcessCPUSeconds_app": 4,
+		"ProcessOpenFDs_app":    3,
+		"ProcessThreads_app":    5,
+		"ProcessUptime_app":     90,
+	}
+	for id, v := range want {
+		m, err := st.GetMetric(id)
+		require.NoError(t, err, id)
+		assert.Equal(t, v, *m.Value, id)
+	}
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/dcaiman/YP_GO/internal/prom"
+	"github.com/go-chi/chi/v5"
+)
+
+// teeStorage writes updates to both storages and reads from the first one.
+// The agent uses it to keep a cumulative copy of everything collected for
+// pull mode, untouched by the counter deductions made after each push.
+type teeStorage struct {
+	metric.MStorage
+	mirror metric.MStorage
+}
+
+func (ts teeStorage) UpdateMetric(m metric.Metric) error {
+	if err := ts.MStorage.UpdateMetric(m); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := ts.mirror.UpdateMetric(m); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (ts teeStorage) UpdateBatch(batch []metric.Metric) error {
+	if err := ts.MStorage.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := ts.mirror.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+// collectStorage is the storage metric sources write to.
+func (agn *AgentConfig) collectStorage() metric.MStorage {
+	if agn.exposed == nil {
+		return agn.Storage
+	}
+	return teeStorage{
+		MStorage: agn.Storage,
+		mirror:   agn.exposed,
+	}
+}
+
+// aggregateStorage is the storage the derived gauge aggregates are written
+// to: the one collectors write to, but past the aggregation.
+func (agn *AgentConfig) aggregateStorage() metric.MStorage {
+	st := agn.aggregates.MStorage
+	if agn.exposed == nil {
+		return st
+	}
+	return teeStorage{
+		MStorage: st,
+		mirror:   agn.exposed,
+	}
+}
+
+func (agn *AgentConfig) startPullServer() {
+	router := chi.NewRouter()
+	router.Get("/metrics", agn.handlerPromMetrics)
+	router.Get("/metrics.json", agn.handlerJSONMetrics)
+	clog.Info("pull endpoint listening", clog.F("addr", agn.Cfg.PullAddr))
+	go func() {
+		clog.Error("pull endpoint stopped", clog.Err(clog.ToLog(clog.FuncName(), http.ListenAndServe(agn.Cfg.PullAddr, router))))
+	}()
+}
+
+func (agn *AgentConfig) handlerPromMetrics(w http.ResponseWriter, r *http.Request) {
+	batch, err := agn.exposed.GetBatch()
+	if err != nil {
+		err := clog.ToLog(clog.FuncName(), err)
+		clog.Error("request failed", clog.F("route", r.URL.Path), clog.Err(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", prom.ContentType)
+	if err := prom.WriteText(w, agn.withLabels(batch)); err != nil {
+		clog.Error("request failed", clog.F("route", r.URL.Path), clog.Err(clog.ToLog(clog.FuncName(), err)))
+	}
+}
+
+func (agn *AgentConfig) handlerJSONMetrics(w http.ResponseWriter, r *http.Request) {
+	batch, err := agn.exposed.GetBatch()
+	if err != nil {
+		err := clog.ToLog(clog.FuncName(), err)
+		clog.Error("request failed", clog.F("route", r.URL.Path), clog.Err(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	batch = agn.withLabels(batch)
+	for i := range batch {
+		if err := batch[i].UpdateHash(agn.Cfg.HashKey); err != nil {
+			err := clog.ToLog(clog.FuncName(), err)
+			clog.Error("request failed", clog.F("route", r.URL.Path), clog.Err(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	mj, err := json.Marshal(batch)
+	if err != nil {
+		err := clog.ToLog(clog.FuncName(), err)
+		clog.Error("request failed", clog.F("route", r.URL.Path), clog.Err(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	header := agn.identityHeader()
+	for k := range header {
+		w.Header().Set(k, header.Get(k))
+	}
+	w.Header().Set("Content-Type", JSONCT)
+	w.Write(mj)
+}
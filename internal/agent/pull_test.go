@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/dcaiman/YP_GO/internal/prom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPullAgent(t *testing.T) *AgentConfig {
+	agn := &AgentConfig{
+		Storage: internalstorage.New("", ""),
+		exposed: internalstorage.New("", ""),
+		Cfg:     EnvConfig{AgentID: "a1", HashKey: "key"},
+		labels:  map[string]string{"dc": "eu"},
+	}
+	del, val := int64(3), 1.5
+	require.NoError(t, agn.collectStorage().UpdateBatch([]metric.Metric{
+		{ID: "PollCount", MType: Counter, Delta: &del},
+		{ID: "Alloc", MType: Gauge, Value: &val},
+	}))
+	return agn
+}
+
+func Test_teeStorage(t *testing.T) {
+	agn := newPullAgent(t)
+	// a push deducts the sent counters from the main storage only
+	sent, err := agn.Storage.GetBatch()
+	require.NoError(t, err)
+	require.NoError(t, agn.subtractCounters(sent))
+	del := int64(2)
+	require.NoError(t, agn.collectStorage().UpdateMetric(metric.Metric{ID: "PollCount", MType: Counter, Delta: &del}))
+
+	m, err := agn.collectStorage().GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *m.Delta, "reads come from the main storage")
+	m, err = agn.exposed.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *m.Delta, "the mirror stays cumulative")
+}
+
+func Test_handlerJSONMetrics(t *testing.T) {
+	agn := newPullAgent(t)
+	rec := httptest.NewRecorder()
+	agn.handlerJSONMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics.json", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, JSONCT, rec.Header().Get("Content-Type"))
+	assert.Equal(t, "a1", rec.Header().Get(metric.AgentIDHeader))
+	batch := []metric.Metric{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &batch))
+	require.Len(t, batch, 2)
+	for _, m := range batch {
+		assert.Equal(t, map[string]string{"dc": "eu"}, m.Labels)
+		signed := m
+		require.NoError(t, signed.UpdateHash("key"))
+		assert.Equal(t, signed.Hash, m.Hash, m.ID)
+	}
+}
+
+func Test_handlerPromMetrics(t *testing.T) {
+	agn := newPullAgent(t)
+	rec := httptest.NewRecorder()
+	agn.handlerPromMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, prom.ContentType, rec.Header().Get("Content-Type"))
+	samples, err := prom.Parse(rec.Body)
+	require.NoError(t, err)
+	got := map[string]float64{}
+	for _, s := range samples {
+		got[s.ID()] = s.Value
+	}
+	assert.Equal(t, map[string]float64{
+		`Alloc{dc="eu"}`:     1.5,
+		`PollCount{dc="eu"}`: 3,
+	}, got)
+}
+
+func Test_reportTickPullOnly(t *testing.T) {
+	st := internalstorage.New("", "")
+	as, err := newAggregatingStorage(st, []string{"Alloc"}, []string{"max"})
+	require.NoError(t, err)
+	agn := &AgentConfig{
+		Storage:    as,
+		aggregates: as,
+		exposed:    internalstorage.New("", ""),
+	}
+	for _, line := range []string{"db.query:12|ms", "Alloc:5|g", "Alloc:2|g"} {
+		sample, err := parseStatsdLine(line)
+		require.NoError(t, err)
+		require.NoError(t, agn.applyStatsd(sample))
+	}
+
+	agn.reportTick()
+	assert.Empty(t, agn.statsd.timers, "the timer window is closed without destinations")
+	assert.Equal(t, int32(0), agn.reporting, "nothing to push to")
+	for id, want := range map[string]float64{"db.query_sum": 12, "Alloc_max": 5} {
+		m, err := agn.exposed.GetMetric(id)
+		require.NoError(t, err, id)
+		assert.Equal(t, want, *m.Value, id)
+	}
+}
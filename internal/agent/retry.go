@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+// postWithRetry sends the body until it is accepted. All attempts share one
+// request ID, so the server logs of a failed report can be found by it.
+func (agn *AgentConfig) postWithRetry(url, contentType, hash string, body []byte) (*http.Response, error) {
+	var lastErr error
+	header := agn.identityHeader()
+	requestID := clog.NewRequestID()
+	header.Set(metric.RequestIDHeader, requestID)
+	ctx := clog.WithRequestID(context.Background(), requestID)
+	if agn.Cfg.Compress != "" && len(body) > 0 {
+		compressed, err := compressedBody(agn.Cfg.Compress, body)
+		if err != nil {
+			return nil, clog.ToLogCtx(ctx, clog.FuncName(), err)
+		}
+		body = compressed
+		header.Set("Content-Encoding", agn.Cfg.Compress)
+	}
+	for attempt := 0; attempt <= agn.Cfg.RetryCount; attempt++ {
+		if attempt > 0 {
+			time.Sleep(agn.retryDelay(attempt-1, lastErr))
+		}
+		res, err := customPostRequest(url, contentType, hash, header, bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			return res, nil
+		}
+		res.Body.Close()
+		lastErr = &statusError{
+			Status:     res.Status,
+			Code:       res.StatusCode,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+		if !isRetryableStatus(res.StatusCode) {
+			break
+		}
+	}
+	return nil, clog.ToLogCtx(ctx, clog.FuncName(), lastErr)
+}
+
+// retryDelay honors Retry-After of the server, but never waits longer than
+// RetryMaxDelay, so a misbehaving server cannot stall the agent.
+func (agn *AgentConfig) retryDelay(attempt int, lastErr error) time.Duration {
+	var se *statusError
+	if errors.As(lastErr, &se) && se.RetryAfter > 0 {
+		if agn.Cfg.RetryMaxDelay > 0 && se.RetryAfter > agn.Cfg.RetryMaxDelay {
+			return agn.Cfg.RetryMaxDelay
+		}
+		return se.RetryAfter
+	}
+	return backoff(attempt, agn.Cfg.RetryMinDelay, agn.Cfg.RetryMaxDelay, agn.Cfg.RetryJitter)
+}
+
+func backoff(attempt int, minDelay, maxDelay time.Duration, jitter float64) time.Duration {
+	d := minDelay
+	for i := 0; i < attempt && (maxDelay <= 0 || d < maxDelay); i++ {
+		d *= 2
+	}
+	if maxDelay > 0 && d > maxDelay {
+		d = maxDelay
+	}
+	if jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		spread := float64(d) * jitter
+		d = time.Duration(float64(d) - spread + 2*spread*rand.Float64())
+	}
+	return d
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(s); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+type statusError struct {
+	Status     string
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return "unexpected response status <" + e.Status + ">"
+}
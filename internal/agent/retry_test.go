@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_postWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		codes    []int
+		wantErr  bool
+		wantHits int
+	}{
+		{"ok", []int{200}, false, 1},
+		{"retry 5xx", []int{500, 503, 200}, false, 3},
+		{"retry 429", []int{429, 200}, false, 2},
+		{"no retry 4xx", []int{400, 200}, true, 1},
+		{"exhausted", []int{500, 500, 500}, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.codes[hits])
+				hits++
+			}))
+			defer ts.Close()
+
+			agn := AgentConfig{Cfg: EnvConfig{RetryCount: 2, RetryMinDelay: time.Millisecond}}
+			res, err := agn.postWithRetry(ts.URL, JSONCT, "", []byte("{}"))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				res.Body.Close()
+			}
+			assert.Equal(t, tt.wantHits, hits)
+		})
+	}
+}
+
+func Test_backoff(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, backoff(0, 100*time.Millisecond, time.Second, 0))
+	assert.Equal(t, 400*time.Millisecond, backoff(2, 100*time.Millisecond, time.Second, 0))
+	assert.Equal(t, time.Second, backoff(10, 100*time.Millisecond, time.Second, 0))
+	for i := 0; i < 100; i++ {
+		d := backoff(1, 100*time.Millisecond, time.Second, 0.5)
+		assert.True(t, d >= 100*time.Millisecond && d <= 300*time.Millisecond, d)
+	}
+}
+
+func Test_retryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxWait time.Duration
+		lastErr error
+		want    time.Duration
+	}{
+		{"backoff", time.Second, &statusError{Code: 503}, 100 * time.Millisecond},
+		{"retry after", time.Second, &statusError{Code: 429, RetryAfter: 500 * time.Millisecond}, 500 * time.Millisecond},
+		{"retry after capped", time.Second, &statusError{Code: 429, RetryAfter: time.Hour}, time.Second},
+		{"retry after without cap", 0, &statusError{Code: 429, RetryAfter: time.Hour}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agn := AgentConfig{Cfg: EnvConfig{RetryMinDelay: 100 * time.Millisecond, RetryMaxDelay: tt.maxWait}}
+			assert.Equal(t, tt.want, agn.retryDelay(0, tt.lastErr))
+		})
+	}
+}
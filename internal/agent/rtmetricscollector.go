@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const rtMetricsPrefix = "go_"
+
+// histogram quantiles reported for every runtime/metrics histogram
+var rtQuantiles = [...]struct {
+	suffix string
+	q      float64
+}{
+	{"_p50", 0.5},
+	{"_p90", 0.9},
+	{"_p99", 0.99},
+}
+
+func init() {
+	RegisterCollector("runtimemetrics", func(cfg EnvConfig) (Collector, error) {
+		return newRTMetricsCollector(), nil
+	})
+}
+
+// rtMetricsCollector reads every supported runtime/metrics value in a single
+// call. Cumulative integers become counters, other scalars gauges, and
+// histograms are reduced to quantiles over the observations made since the
+// previous collect plus an observation counter. Quantiles are not described
+// in advance: they have no value until a window with observations.
+type rtMetricsCollector struct {
+	mu       sync.Mutex
+	samples  []metrics.Sample
+	descs    map[string]metrics.Description
+	counts   deltas
+	prevHist map[string][]uint64
+}
+
+func newRTMetricsCollector() *rtMetricsCollector {
+	all := metrics.All()
+	rc := &rtMetricsCollector{
+		samples:  make([]metrics.Sample, 0, len(all)),
+		descs:    map[string]metrics.Description{},
+		prevHist: map[string][]uint64{},
+	}
+	for i := range all {
+		if all[i].Kind == metrics.KindBad {
+			continue
+		}
+		rc.samples = append(rc.samples, metrics.Sample{Name: all[i].Name})
+		rc.descs[all[i].Name] = all[i]
+	}
+	return rc
+}
+
+func (rc *rtMetricsCollector) Describe() []metric.Metric {
+	ms := []metric.Metric{}
+	for i := range rc.samples {
+		d := rc.descs[rc.samples[i].Name]
+		id := rtMetricsPrefix + metricSuffix(d.Name)
+		if d.Kind == metrics.KindFloat64Histogram || d.Kind == metrics.KindUint64 && d.Cumulative {
+			continue
+		}
+		ms = append(ms, metric.Metric{ID: id, MType: Gauge})
+	}
+	return ms
+}
+
+func (rc *rtMetricsCollector) Collect(st metric.MStorage) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	metrics.Read(rc.samples)
+
+	batch := []metric.Metric{}
+	for i := range rc.samples {
+		s := rc.samples[i]
+		d := rc.descs[s.Name]
+		id := rtMetricsPrefix + metricSuffix(s.Name)
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			v := s.Value.Uint64()
+			if d.Cumulative {
+				if m, ok := rc.counts.counter(id, int64(v)); ok {
+					batch = append(batch, m)
+				}
+				continue
+			}
+			batch = append(batch, gaugeMetric(id, float64(v)))
+		case metrics.KindFloat64:
+			batch = append(batch, gaugeMetric(id, s.Value.Float64()))
+		case metrics.KindFloat64Histogram:
+			batch = append(batch, rc.histogram(id, s.Value.Float64Histogram())...)
+		}
+	}
+	if err := st.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (rc *rtMetricsCollector) histogram(id string, h *metrics.Float64Histogram) []metric.Metric {
+	prev := rc.prevHist[id]
+	window, total := histogramWindow(prev, h.Counts)
+	var cumulative uint64
+	for i := range h.Counts {
+		cumulative += h.Counts[i]
+	}
+	rc.prevHist[id] = append(prev[:0], h.Counts...)
+
+	batch := []metric.Metric{}
+	if m, ok := rc.counts.counter(id+"_count", int64(cumulative)); ok {
+		batch = append(batch, m)
+	}
+	if total == 0 {
+		return batch
+	}
+	for _, q := range rtQuantiles {
+		batch = append(batch, gaugeMetric(id+q.suffix, histogramQuantile(window, h.Buckets, total, q.q)))
+	}
+	return batch
+}
+
+// histogramWindow returns the bucket counts observed since prev and their
+// total. A bucket that went down, or a changed bucket layout, counts in full.
+func histogramWindow(prev, counts []uint64) ([]uint64, uint64) {
+	window := make([]uint64, len(counts))
+	var total uint64
+	for i := range counts {
+		window[i] = counts[i]
+		if len(prev) == len(counts) && counts[i] >= prev[i] {
+			window[i] -= prev[i]
+		}
+		total += window[i]
+	}
+	return window, total
+}
+
+// histogramQuantile returns the upper bound of the bucket holding the
+// quantile, falling back to the lower bound for the open-ended last bucket.
+func histogramQuantile(counts []uint64, buckets []float64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var cumulative uint64
+	for i := range counts {
+		cumulative += counts[i]
+		if cumulative < rank {
+			continue
+		}
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper
+		}
+		if lower := buckets[i]; !math.IsInf(lower, -1) {
+			return lower
+		}
+		return 0
+	}
+	return 0
+}
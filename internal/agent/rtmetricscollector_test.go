@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_histogramWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		prev       []uint64
+		counts     []uint64
+		wantWindow []uint64
+		wantTotal  uint64
+	}{
+		{"first collect", nil, []uint64{1, 2, 3}, []uint64{1, 2, 3}, 6},
+		{"growth", []uint64{1, 2, 3}, []uint64{1, 5, 4}, []uint64{0, 3, 1}, 4},
+		{"no change", []uint64{1, 2, 3}, []uint64{1, 2, 3}, []uint64{0, 0, 0}, 0},
+		{"bucket went down", []uint64{4, 2}, []uint64{1, 3}, []uint64{1, 1}, 2},
+		{"layout changed", []uint64{1, 2}, []uint64{1, 2, 3}, []uint64{1, 2, 3}, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, total := histogramWindow(tt.prev, tt.counts)
+			assert.Equal(t, tt.wantWindow, window)
+			assert.Equal(t, tt.wantTotal, total)
+		})
+	}
+}
+
+func Test_histogramQuantile(t *testing.T) {
+	inf := math.Inf(1)
+	buckets := []float64{math.Inf(-1), 1, 2, 4, inf}
+	tests := []struct {
+		name   string
+		counts []uint64
+		q      float64
+		want   float64
+	}{
+		{"median in second bucket", []uint64{0, 2, 2, 0}, 0.5, 2},
+		{"p99 in third bucket", []uint64{0, 2, 2, 1}, 0.99, 4},
+		{"first bucket open below", []uint64{3, 0, 0, 0}, 0.5, 1},
+		{"last bucket open above", []uint64{0, 0, 0, 3}, 0.9, 4},
+		{"zero quantile", []uint64{0, 1, 1, 0}, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var total uint64
+			for _, c := range tt.counts {
+				total += c
+			}
+			assert.Equal(t, tt.want, histogramQuantile(tt.counts, buckets, total, tt.q))
+		})
+	}
+	assert.Equal(t, float64(0), histogramQuantile([]uint64{1}, []float64{math.Inf(-1), inf}, 1, 0.5))
+}
+
+func Test_rtMetricsCollectorQuantiles(t *testing.T) {
+	rc := newRTMetricsCollector()
+	for _, m := range rc.Describe() {
+		for _, q := range rtQuantiles {
+			assert.False(t, strings.HasSuffix(m.ID, q.suffix), "quantile %s is described without a value", m.ID)
+		}
+	}
+
+	st := internalstorage.New("", "")
+	require.NoError(t, rc.Collect(st))
+	batch, err := st.GetBatch()
+	require.NoError(t, err)
+	for i := range batch {
+		assert.True(t, hasValue(batch[i]), batch[i].ID)
+	}
+}
@@ -5,21 +5,37 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/compress"
+	"github.com/dcaiman/YP_GO/internal/metric"
 )
 
 func (agn *AgentConfig) sendMetric(name string) error {
-	var url, val string
-	var body []byte
-
 	m, err := agn.Storage.GetMetric(name)
 	if err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
+	if !hasValue(m) {
+		return nil
+	}
+	if err := agn.deliver([]metric.Metric{m}, agn.postMetric); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (agn *AgentConfig) postMetric(dest *destination, batch []metric.Metric) error {
+	var url, val string
+	var body []byte
+
+	m := agn.withLabels(batch)[0]
+	if !hasValue(m) {
+		return nil
+	}
 	if err := m.UpdateHash(agn.Cfg.HashKey); err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
@@ -34,54 +50,78 @@ func (agn *AgentConfig) sendMetric(name string) error {
 		default:
 			return clog.ToLog(clog.FuncName(), errors.New("cannot send: unsupported metric type <"+m.MType+">"))
 		}
-		url = agn.Cfg.SrvAddr + "/update/" + m.MType + "/" + m.ID + "/" + val
+		url = dest.addr + "/update/" + m.MType + "/" + m.ID + "/" + val
 		body = nil
 	case JSONCT:
 		tmpBody, err := json.Marshal(m)
 		if err != nil {
 			return clog.ToLog(clog.FuncName(), err)
 		}
-		url = agn.Cfg.SrvAddr + "/update/"
+		url = dest.addr + "/update/"
 		body = tmpBody
 	default:
 		return clog.ToLog(clog.FuncName(), errors.New("cannot send: unsupported content type <"+agn.Cfg.CType+">"))
 	}
-	res, err := customPostRequest(HTTPStr+url, agn.Cfg.CType, m.Hash, bytes.NewBuffer(body))
+	start := time.Now()
+	res, err := agn.postWithRetry(HTTPStr+url, agn.Cfg.CType, m.Hash, body)
 	if err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
 	defer res.Body.Close()
-	if m.MType == Counter {
-		if err := agn.resetCounter(name); err != nil {
-			return clog.ToLog(clog.FuncName(), err)
-		}
-	}
-	log.Println("SEND METRIC: ", res.Status, res.Request.URL)
+	clog.Debug("metric sent",
+		clog.F("dest", dest.addr),
+		clog.F("metric", m.ID),
+		clog.F("status", res.StatusCode),
+		clog.F("latency", time.Since(start)),
+		clog.F("request_id", res.Request.Header.Get(metric.RequestIDHeader)),
+	)
 	return nil
 }
 
 func (agn *AgentConfig) sendBatch() error {
-	body, err := agn.getStorageBatch()
+	batch, err := agn.Storage.GetBatch()
 	if err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
-	res, err := customPostRequest(HTTPStr+agn.Cfg.SrvAddr+"/updates/", JSONCT, "", bytes.NewBuffer(body))
+	batch = withValues(batch)
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := agn.deliver(batch, agn.postBatch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (agn *AgentConfig) postBatch(dest *destination, batch []metric.Metric) error {
+	body, err := agn.encodeBatch(agn.withLabels(batch))
 	if err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
-	defer res.Body.Close()
-	if err := agn.resetCounters(); err != nil {
+	start := time.Now()
+	res, err := agn.postWithRetry(HTTPStr+dest.addr+"/updates/", JSONCT, "", body)
+	if err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
-	log.Println("SEND BATCH: ", res.Status, res.Request.URL)
+	defer res.Body.Close()
+	clog.Info("batch sent",
+		clog.F("dest", dest.addr),
+		clog.F("metrics", len(batch)),
+		clog.F("status", res.StatusCode),
+		clog.F("latency", time.Since(start)),
+		clog.F("request_id", res.Request.Header.Get(metric.RequestIDHeader)),
+	)
 	return nil
 }
 
-func customPostRequest(url, contentType, hash string, body io.Reader) (resp *http.Response, err error) {
+func customPostRequest(url, contentType, hash string, header http.Header, body io.Reader) (resp *http.Response, err error) {
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
 		return nil, clog.ToLog(clog.FuncName(), err)
 	}
+	for k := range header {
+		req.Header.Set(k, header.Get(k))
+	}
 	if hash != "" {
 		req.Header.Set("Hash", hash)
 	}
@@ -95,18 +135,17 @@ func customPostRequest(url, contentType, hash string, body io.Reader) (resp *htt
 	return client, nil
 }
 
-/*
-func compressedBody(body []byte) (io.Reader, error) {
+func compressedBody(enc string, body []byte) ([]byte, error) {
 	var buf bytes.Buffer
-	gw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	cw, err := compress.NewWriter(enc, &buf)
 	if err != nil {
 		return nil, clog.ToLog(clog.FuncName(), err)
 	}
-	_, err = gw.Write(body)
-	if err != nil {
+	if _, err = cw.Write(body); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	if err = cw.Close(); err != nil {
 		return nil, clog.ToLog(clog.FuncName(), err)
 	}
-	gw.Close()
-	return bytes.NewReader(buf.Bytes()), nil
+	return buf.Bytes(), nil
 }
-*/
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const spoolExt = ".json"
+
+type spool struct {
+	sync.Mutex
+	dir   string
+	limit int
+}
+
+func newSpool(dir string, limit int) (*spool, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return &spool{
+		dir:   dir,
+		limit: limit,
+	}, nil
+}
+
+func (sp *spool) segments() ([]string, error) {
+	entries, err := os.ReadDir(sp.dir)
+	if err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	names := []string{}
+	for i := range entries {
+		if entries[i].IsDir() || !strings.HasSuffix(entries[i].Name(), spoolExt) {
+			continue
+		}
+		names = append(names, filepath.Join(sp.dir, entries[i].Name()))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (sp *spool) push(batch []metric.Metric) error {
+	sp.Lock()
+	defer sp.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	seg := internalstorage.New(filepath.Join(sp.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), spoolExt)), "")
+	if err := seg.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := seg.UploadStorage(); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+
+	names, err := sp.segments()
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	for len(names) > sp.limit {
+		if err := mergeSegments(names[0], names[1]); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// mergeSegments folds the older segment into the newer one, so counters
+// are summed and gauges keep the newer value.
+func mergeSegments(older, newer string) error {
+	seg := internalstorage.New(older, "")
+	if err := seg.DownloadStorage(); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	seg.FilePath = newer
+	if err := seg.DownloadStorage(); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := seg.UploadStorage(); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := os.Remove(older); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+func (sp *spool) drain(send func(batch []metric.Metric) error) error {
+	sp.Lock()
+	defer sp.Unlock()
+
+	names, err := sp.segments()
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	for i := range names {
+		seg := internalstorage.New(names[i], "")
+		if err := seg.DownloadStorage(); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		batch, err := seg.GetBatch()
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		if len(batch) > 0 {
+			if err := send(batch); err != nil {
+				return clog.ToLog(clog.FuncName(), err)
+			}
+		}
+		if err := os.Remove(names[i]); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		clog.Info("spool segment drained", clog.F("segment", names[i]))
+	}
+	return nil
+}
+
+func (agn *AgentConfig) spoolMetrics(batch []metric.Metric) error {
+	if agn.spool == nil {
+		return clog.ToLog(clog.FuncName(), errors.New("spool is not configured"))
+	}
+	if err := agn.spool.push(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := agn.subtractCounters(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+// drainBatch sends a spooled segment to the first destination that accepts
+// it. Spooling is only enabled when a single server owns each report.
+func (agn *AgentConfig) drainBatch(batch []metric.Metric) error {
+	var errs []string
+	for _, d := range agn.dests {
+		if err := agn.try(d, batch, agn.postBatch); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return nil
+	}
+	return clog.ToLog(clog.FuncName(), errors.New(strings.Join(errs, "; ")))
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func counter(id string, del int64) metric.Metric {
+	return metric.Metric{ID: id, MType: Counter, Delta: &del}
+}
+
+// drained collects the batches a drain sends, keyed by metric ID.
+func drained(t *testing.T, sp *spool) []map[string]metric.Metric {
+	res := []map[string]metric.Metric{}
+	require.NoError(t, sp.drain(func(batch []metric.Metric) error {
+		ms := map[string]metric.Metric{}
+		for i := range batch {
+			ms[batch[i].ID] = batch[i]
+		}
+		res = append(res, ms)
+		return nil
+	}))
+	return res
+}
+
+func Test_spoolLimit(t *testing.T) {
+	sp, err := newSpool(filepath.Join(t.TempDir(), "spool"), 2)
+	require.NoError(t, err)
+
+	require.NoError(t, sp.push([]metric.Metric{counter("PollCount", 1), gaugeMetric("Alloc", 1)}))
+	require.NoError(t, sp.push([]metric.Metric{counter("PollCount", 2), gaugeMetric("Alloc", 2)}))
+	require.NoError(t, sp.push([]metric.Metric{counter("PollCount", 4), gaugeMetric("Free", 3)}))
+	require.NoError(t, sp.push(nil))
+
+	names, err := sp.segments()
+	require.NoError(t, err)
+	assert.Len(t, names, 2, "the oldest segments are merged to stay within the limit")
+
+	batches := drained(t, sp)
+	require.Len(t, batches, 2)
+	assert.Equal(t, int64(3), *batches[0]["PollCount"].Delta, "merged counters are summed")
+	assert.Equal(t, 2.0, *batches[0]["Alloc"].Value, "merged gauges keep the newer value")
+	assert.Equal(t, int64(4), *batches[1]["PollCount"].Delta)
+	assert.Equal(t, 3.0, *batches[1]["Free"].Value)
+
+	names, err = sp.segments()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func Test_mergeSegments(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := newSpool(dir, 10)
+	require.NoError(t, err)
+	require.NoError(t, sp.push([]metric.Metric{counter("PollCount", 5), gaugeMetric("Alloc", 1)}))
+	require.NoError(t, sp.push([]metric.Metric{counter("PollCount", 7), gaugeMetric("Alloc", 2), gaugeMetric("Free", 3)}))
+	names, err := sp.segments()
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+
+	require.NoError(t, mergeSegments(names[0], names[1]))
+	_, err = os.Stat(names[0])
+	assert.True(t, os.IsNotExist(err), "the older segment is removed")
+
+	batches := drained(t, sp)
+	require.Len(t, batches, 1)
+	assert.Equal(t, int64(12), *batches[0]["PollCount"].Delta)
+	assert.Equal(t, 2.0, *batches[0]["Alloc"].Value)
+	assert.Equal(t, 3.0, *batches[0]["Free"].Value)
+}
+
+func Test_spoolDrainFailure(t *testing.T) {
+	sp, err := newSpool(t.TempDir(), 10)
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, sp.push([]metric.Metric{counter("PollCount", i)}))
+	}
+
+	sent := []int64{}
+	err = sp.drain(func(batch []metric.Metric) error {
+		if len(sent) == 1 {
+			return errors.New("server is down")
+		}
+		sent = append(sent, *batch[0].Delta)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []int64{1}, sent, "segments are sent oldest first")
+
+	names, err := sp.segments()
+	require.NoError(t, err)
+	assert.Len(t, names, 2, "only the delivered segment is deleted")
+
+	batches := drained(t, sp)
+	require.Len(t, batches, 2)
+	assert.Equal(t, int64(2), *batches[0]["PollCount"].Delta)
+	assert.Equal(t, int64(3), *batches[1]["PollCount"].Delta)
+}
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const statsdMaxPacket = 65535
+
+type statsdSample struct {
+	name     string
+	kind     string
+	value    float64
+	rate     float64
+	relative bool
+}
+
+// statsdState keeps the timer windows between reports and serializes the
+// samples, so that a relative gauge is read and written as one update.
+type statsdState struct {
+	mu     sync.Mutex
+	timers map[string]*statsdTimer
+}
+
+type statsdTimer struct {
+	count         int64
+	sum, min, max float64
+}
+
+func (agn *AgentConfig) startStatsd() error {
+	conn, err := net.ListenPacket("udp", agn.Cfg.StatsdAddr)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	clog.Info("statsd listening", clog.F("proto", "udp"), clog.F("addr", conn.LocalAddr()))
+	go agn.serveStatsdUDP(conn)
+
+	if agn.Cfg.StatsdTCP {
+		ln, err := net.Listen("tcp", agn.Cfg.StatsdAddr)
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		clog.Info("statsd listening", clog.F("proto", "tcp"), clog.F("addr", ln.Addr()))
+		go agn.serveStatsdTCP(ln)
+	}
+	return nil
+}
+
+func (agn *AgentConfig) serveStatsdUDP(conn net.PacketConn) {
+	buf := make([]byte, statsdMaxPacket)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			clog.Error("statsd stopped", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			return
+		}
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			agn.handleStatsdLine(string(line))
+		}
+	}
+}
+
+func (agn *AgentConfig) serveStatsdTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			clog.Error("statsd stopped", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			s := bufio.NewScanner(conn)
+			for s.Scan() {
+				agn.handleStatsdLine(s.Text())
+			}
+		}(conn)
+	}
+}
+
+func (agn *AgentConfig) handleStatsdLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	sample, err := parseStatsdLine(line)
+	if err != nil {
+		clog.Warn("statsd line rejected", clog.F("line", line), clog.Err(clog.ToLog(clog.FuncName(), err)))
+		return
+	}
+	if err := agn.applyStatsd(sample); err != nil {
+		clog.Warn("statsd line rejected", clog.F("line", line), clog.Err(clog.ToLog(clog.FuncName(), err)))
+	}
+}
+
+// parseStatsdLine reads name:value|type[|@rate][|#tags]; tags are ignored.
+func parseStatsdLine(line string) (statsdSample, error) {
+	sample := statsdSample{rate: 1}
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample, clog.ToLog(clog.FuncName(), errors.New("invalid statsd line <"+line+">"))
+	}
+	sample.name = name
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return sample, clog.ToLog(clog.FuncName(), errors.New("invalid statsd line <"+line+">"))
+	}
+	sample.kind = parts[1]
+	if sample.kind == "g" && (strings.HasPrefix(parts[0], "+") || strings.HasPrefix(parts[0], "-")) {
+		sample.relative = true
+	}
+	val, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return sample, clog.ToLog(clog.FuncName(), err)
+	}
+	sample.value = val
+	for _, p := range parts[2:] {
+		if !strings.HasPrefix(p, "@") {
+			continue
+		}
+		rate, err := strconv.ParseFloat(p[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return sample, clog.ToLog(clog.FuncName(), errors.New("invalid sample rate <"+p+">"))
+		}
+		sample.rate = rate
+	}
+	switch sample.kind {
+	case "c", "g", "ms", "h":
+	default:
+		return sample, clog.ToLog(clog.FuncName(), errors.New("unsupported statsd type <"+sample.kind+">"))
+	}
+	return sample, nil
+}
+
+func (agn *AgentConfig) applyStatsd(sample statsdSample) error {
+	agn.statsd.mu.Lock()
+	defer agn.statsd.mu.Unlock()
+
+	batch := []metric.Metric{}
+	switch sample.kind {
+	case "c":
+		del := int64(math.Round(sample.value / sample.rate))
+		batch = append(batch, metric.Metric{
+			ID:    sample.name,
+			MType: Counter,
+			Delta: &del,
+		})
+	case "g":
+		val := sample.value
+		if sample.relative {
+			if m, err := agn.Storage.GetMetric(sample.name); err == nil && m.Value != nil {
+				val += *m.Value
+			}
+		}
+		batch = append(batch, gaugeMetric(sample.name, val))
+	case "ms", "h":
+		agn.statsd.observeTimer(sample)
+		return nil
+	}
+	if err := agn.collectStorage().UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+// observeTimer adds a sample to the window of the timer, a sampled value
+// weighs as many samples as it stands for.
+func (ss *statsdState) observeTimer(sample statsdSample) {
+	if ss.timers == nil {
+		ss.timers = map[string]*statsdTimer{}
+	}
+	cnt := int64(math.Round(1 / sample.rate))
+	t, ok := ss.timers[sample.name]
+	if !ok {
+		t = &statsdTimer{min: sample.value, max: sample.value}
+		ss.timers[sample.name] = t
+	}
+	t.count += cnt
+	t.sum += sample.value * float64(cnt)
+	t.min = math.Min(t.min, sample.value)
+	t.max = math.Max(t.max, sample.value)
+}
+
+// flushStatsd writes the timer windows as <name> (the mean), <name>_sum,
+// <name>_min and <name>_max gauges and a <name>_count counter, and starts
+// new windows.
+func (agn *AgentConfig) flushStatsd() error {
+	agn.statsd.mu.Lock()
+	timers := agn.statsd.timers
+	agn.statsd.timers = nil
+	agn.statsd.mu.Unlock()
+
+	names := make([]string, 0, len(timers))
+	for name := range timers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	batch := []metric.Metric{}
+	for _, name := range names {
+		t := timers[name]
+		cnt := t.count
+		batch = append(batch,
+			gaugeMetric(name, t.sum/float64(t.count)),
+			gaugeMetric(name+"_sum", t.sum),
+			gaugeMetric(name+"_min", t.min),
+			gaugeMetric(name+"_max", t.max),
+			metric.Metric{ID: name + "_count", MType: Counter, Delta: &cnt},
+		)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := agn.collectStorage().UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
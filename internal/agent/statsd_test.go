@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_applyStatsd(t *testing.T) {
+	agn := AgentConfig{Storage: internalstorage.New("", "")}
+	for _, line := range []string{
+		"hits:1|c",
+		"hits:2|c|@0.5",
+		"temp:3.2|g",
+		"temp:-1.2|g",
+		"db.query:12|ms|#env:prod",
+	} {
+		sample, err := parseStatsdLine(line)
+		assert.NoError(t, err, line)
+		assert.NoError(t, agn.applyStatsd(sample), line)
+	}
+
+	m, _ := agn.Storage.GetMetric("hits")
+	assert.Equal(t, int64(5), *m.Delta)
+	m, _ = agn.Storage.GetMetric("temp")
+	assert.InDelta(t, 2.0, *m.Value, 1e-9)
+	_, err := agn.Storage.GetMetric("db.query")
+	assert.Error(t, err, "timers are written on flush")
+
+	for _, line := range []string{"hits", "hits:1", "hits:x|c", "users:1|s", "hits:1|c|@2"} {
+		_, err := parseStatsdLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func Test_statsdTimers(t *testing.T) {
+	agn := AgentConfig{Storage: internalstorage.New("", "")}
+	apply := func(lines ...string) {
+		for _, line := range lines {
+			sample, err := parseStatsdLine(line)
+			require.NoError(t, err, line)
+			require.NoError(t, agn.applyStatsd(sample), line)
+		}
+	}
+	value := func(id string) float64 {
+		m, err := agn.Storage.GetMetric(id)
+		require.NoError(t, err, id)
+		return *m.Value
+	}
+	delta := func(id string) int64 {
+		m, err := agn.Storage.GetMetric(id)
+		require.NoError(t, err, id)
+		return *m.Delta
+	}
+
+	apply("db.query:12|ms", "db.query:4|ms", "db.query:20|h|@0.5")
+	require.NoError(t, agn.flushStatsd())
+	assert.Equal(t, 14.0, value("db.query"))
+	assert.Equal(t, 56.0, value("db.query_sum"))
+	assert.Equal(t, 4.0, value("db.query_min"))
+	assert.Equal(t, 20.0, value("db.query_max"))
+	assert.Equal(t, int64(4), delta("db.query_count"))
+
+	// every window starts empty
+	apply("db.query:30|ms")
+	require.NoError(t, agn.flushStatsd())
+	assert.Equal(t, 30.0, value("db.query_min"))
+	assert.Equal(t, 30.0, value("db.query_max"))
+	assert.Equal(t, int64(5), delta("db.query_count"))
+
+	require.NoError(t, agn.flushStatsd())
+	assert.Equal(t, int64(5), delta("db.query_count"), "empty windows write nothing")
+}
+
+func Test_statsdRelativeGaugeConcurrent(t *testing.T) {
+	agn := AgentConfig{Storage: internalstorage.New("", "")}
+	sample, err := parseStatsdLine("conns:+1|g")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.NoError(t, agn.applyStatsd(sample))
+			}
+		}()
+	}
+	wg.Wait()
+	m, err := agn.Storage.GetMetric("conns")
+	require.NoError(t, err)
+	assert.Equal(t, 800.0, *m.Value)
+}
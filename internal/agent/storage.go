@@ -3,72 +3,49 @@ package agent
 import (
 	"encoding/json"
 	"errors"
-	"log"
-	"math/rand"
-	"reflect"
-	"runtime"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
 	"github.com/dcaiman/YP_GO/internal/metric"
 )
 
 func (agn *AgentConfig) prepareStorage() error {
-	if err := agn.createMetrics(runtimeGauges[:], Gauge); err != nil {
-		return clog.ToLog(clog.FuncName(), err)
-	}
-	if err := agn.createMetrics(customGauges[:], Gauge); err != nil {
-		return clog.ToLog(clog.FuncName(), err)
-	}
-	if err := agn.createMetrics(counters[:], Counter); err != nil {
-		return clog.ToLog(clog.FuncName(), err)
-	}
-	return nil
-}
-
-func (agn *AgentConfig) createMetrics(mNames []string, mType string) error {
-	for i := range mNames {
-		m := metric.Metric{
-			ID:    mNames[i],
-			MType: mType,
+	for i := range agn.collectors {
+		ms := agn.collectors[i].collector.Describe()
+		if len(ms) == 0 {
+			continue
 		}
-		if err := agn.Storage.UpdateMetric(m); err != nil {
+		if err := agn.collectStorage().UpdateBatch(ms); err != nil {
 			return clog.ToLog(clog.FuncName(), err)
 		}
 	}
 	return nil
 }
 
-func (agn *AgentConfig) poll() error {
-	for i := range runtimeGauges {
-		val := getRuntimeMetricValue(runtimeGauges[i])
-		m := metric.Metric{
-			ID:    runtimeGauges[i],
-			MType: Gauge,
-			Value: &val,
-		}
-		if err := agn.Storage.UpdateMetric(m); err != nil {
-			return clog.ToLog(clog.FuncName(), err)
+// hasValue is false for placeholders created by Describe for collectors
+// that have not run yet.
+func hasValue(m metric.Metric) bool {
+	return m.Value != nil || m.Delta != nil
+}
+
+func withValues(batch []metric.Metric) []metric.Metric {
+	res := make([]metric.Metric, 0, len(batch))
+	for i := range batch {
+		if hasValue(batch[i]) {
+			res = append(res, batch[i])
 		}
 	}
-	for i := range customGauges {
-		val := 100 * rand.Float64()
-		m := metric.Metric{
-			ID:    customGauges[i],
-			MType: Gauge,
-			Value: &val,
-		}
-		if err := agn.Storage.UpdateMetric(m); err != nil {
-			return clog.ToLog(clog.FuncName(), err)
-		}
+	return res
+}
+
+// flushWindows closes the statsd timer and gauge aggregation windows. It
+// runs on every report tick, also without destinations to push to, so the
+// windows never outgrow one report interval.
+func (agn *AgentConfig) flushWindows() error {
+	if err := agn.flushStatsd(); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
 	}
-	for i := range counters {
-		var del int64 = 1
-		m := metric.Metric{
-			ID:    counters[i],
-			MType: Counter,
-			Delta: &del,
-		}
-		if err := agn.Storage.UpdateMetric(m); err != nil {
+	if agn.aggregates != nil {
+		if err := agn.aggregates.flush(agn.aggregateStorage()); err != nil {
 			return clog.ToLog(clog.FuncName(), err)
 		}
 	}
@@ -76,48 +53,41 @@ func (agn *AgentConfig) poll() error {
 }
 
 func (agn *AgentConfig) report(sendBatch bool) error {
+	if agn.spool != nil {
+		if err := agn.spool.drain(agn.drainBatch); err != nil {
+			clog.Warn("spool drain failed", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			batch, err := agn.Storage.GetBatch()
+			if err != nil {
+				return clog.ToLog(clog.FuncName(), err)
+			}
+			if err := agn.spoolMetrics(withValues(batch)); err != nil {
+				return clog.ToLog(clog.FuncName(), err)
+			}
+			return nil
+		}
+	}
 	if sendBatch {
 		if err := agn.sendBatch(); err != nil {
 			return clog.ToLog(clog.FuncName(), err)
 		}
 		return nil
 	}
-	for i := range runtimeGauges {
-		go func(i int) {
-			if err := agn.sendMetric(runtimeGauges[i]); err != nil {
-				log.Println(clog.ToLog(clog.FuncName(), err))
-			}
-		}(i)
+	batch, err := agn.Storage.GetBatch()
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
 	}
-	for i := range customGauges {
-		go func(i int) {
-			if err := agn.sendMetric(customGauges[i]); err != nil {
-				log.Println(clog.ToLog(clog.FuncName(), err))
-			}
-		}(i)
+	batch = withValues(batch)
+	names := make([]string, 0, len(batch))
+	for i := range batch {
+		names = append(names, batch[i].ID)
 	}
-	for i := range counters {
-		go func(i int) {
-			if err := agn.sendMetric(counters[i]); err != nil {
-				log.Println(clog.ToLog(clog.FuncName(), err))
-			}
-		}(i)
+	if err := agn.sendAll(names); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
 	}
 	return nil
 }
 
-func getRuntimeMetricValue(name string) float64 {
-	mem := &runtime.MemStats{}
-	runtime.ReadMemStats(mem)
-	return reflect.Indirect(reflect.ValueOf(mem)).FieldByName(name).Convert(reflect.TypeOf(0.0)).Float()
-}
-
-func (agn *AgentConfig) getStorageBatch() ([]byte, error) {
-	allMetrics, err := agn.Storage.GetBatch()
-	if err != nil {
-		return nil, clog.ToLog(clog.FuncName(), err)
-	}
-
+func (agn *AgentConfig) encodeBatch(allMetrics []metric.Metric) ([]byte, error) {
 	var mj []byte
 	for i := range allMetrics {
 		if err := allMetrics[i].UpdateHash(agn.Cfg.HashKey); err != nil {
@@ -136,18 +106,25 @@ func (agn *AgentConfig) getStorageBatch() ([]byte, error) {
 	return mj, nil
 }
 
-func (agn *AgentConfig) resetCounters() error {
-	if err := agn.createMetrics(counters[:], Counter); err != nil {
-		return clog.ToLog(clog.FuncName(), err)
+// subtractCounters deducts exactly the deltas that were sent, so increments
+// made by poll after the snapshot was taken are kept for the next report.
+func (agn *AgentConfig) subtractCounters(sent []metric.Metric) error {
+	batch := []metric.Metric{}
+	for i := range sent {
+		if sent[i].MType != Counter || sent[i].Delta == nil || *sent[i].Delta == 0 {
+			continue
+		}
+		del := -*sent[i].Delta
+		batch = append(batch, metric.Metric{
+			ID:    sent[i].ID,
+			MType: Counter,
+			Delta: &del,
+		})
 	}
-	return nil
-}
-
-func (agn *AgentConfig) resetCounter(name string) error {
-	if err := agn.Storage.UpdateMetric(metric.Metric{
-		ID:    name,
-		MType: Counter,
-	}); err != nil {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := agn.Storage.UpdateBatch(batch); err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
 	return nil
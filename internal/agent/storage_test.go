@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_subtractCounters(t *testing.T) {
+	agn := AgentConfig{Storage: internalstorage.New("", "")}
+	inc := func(n int64) {
+		assert.NoError(t, agn.Storage.UpdateMetric(metric.Metric{ID: "PollCount", MType: Counter, Delta: &n}))
+	}
+
+	inc(3)
+	snapshot, err := agn.Storage.GetBatch()
+	assert.NoError(t, err)
+	inc(2)
+	assert.NoError(t, agn.subtractCounters(snapshot))
+
+	m, err := agn.Storage.GetMetric("PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), *m.Delta)
+}
+
+type slowCollector struct{}
+
+func (slowCollector) Describe() []metric.Metric {
+	return []metric.Metric{{ID: "Slow", MType: Gauge}, {ID: "SlowCount", MType: Counter}}
+}
+
+func (slowCollector) Collect(st metric.MStorage) error {
+	return nil
+}
+
+func Test_reportSkipsPlaceholders(t *testing.T) {
+	var mu sync.Mutex
+	got := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, r.URL.Path+" "+string(body))
+	}))
+	defer ts.Close()
+
+	agn := AgentConfig{
+		Storage: internalstorage.New("", ""),
+		Cfg:     EnvConfig{SrvAddr: strings.TrimPrefix(ts.URL, HTTPStr), CType: TextPlainCT},
+		collectors: []activeCollector{
+			{name: "slow", collector: slowCollector{}, interval: time.Hour},
+		},
+	}
+	require.NoError(t, agn.initDestinations())
+	agn.startWorkers()
+	require.NoError(t, agn.prepareStorage())
+
+	assert.NoError(t, agn.report(false))
+	assert.NoError(t, agn.report(true))
+	assert.Empty(t, got)
+
+	val := 1.5
+	require.NoError(t, agn.Storage.UpdateMetric(metric.Metric{ID: "Fast", MType: Gauge, Value: &val}))
+	assert.NoError(t, agn.report(false))
+	assert.NoError(t, agn.report(true))
+	require.Len(t, got, 2)
+	assert.Equal(t, "/update/gauge/Fast/1.500 ", got[0])
+	assert.NotContains(t, got[1], "Slow")
+	assert.Contains(t, got[1], "Fast")
+}
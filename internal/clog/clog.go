@@ -1,6 +1,8 @@
 package clog
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"strings"
@@ -8,13 +10,19 @@ import (
 )
 
 type Log struct {
-	Label   string
-	OccTime time.Time
-	Err     error
+	Label     string
+	OccTime   time.Time
+	RequestID string
+	Err       error
 }
 
+// Error leaves the occurrence time out, log entries carry their own. The
+// request ID stays in the text so that it survives joined errors.
 func (l *Log) Error() string {
-	return fmt.Sprintf("%v (%v) --> %v", l.Label, l.OccTime.Format(`15:04:05`), l.Err)
+	if l.RequestID != "" {
+		return fmt.Sprintf("%v [request %v] --> %v", l.Label, l.RequestID, l.Err)
+	}
+	return fmt.Sprintf("%v --> %v", l.Label, l.Err)
 }
 
 func (l *Log) Unwrap() error {
@@ -29,6 +37,26 @@ func ToLog(label string, err error) error {
 	}
 }
 
+// ToLogCtx is ToLog keeping the request ID of the context with the error.
+func ToLogCtx(ctx context.Context, label string, err error) error {
+	return &Log{
+		Label:     label,
+		OccTime:   time.Now(),
+		RequestID: RequestID(ctx),
+		Err:       err,
+	}
+}
+
+// RequestIDOf returns the first request ID found in the error chain.
+func RequestIDOf(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if l, ok := err.(*Log); ok && l.RequestID != "" {
+			return l.RequestID
+		}
+	}
+	return ""
+}
+
 func FuncName() string {
 	counter, _, _, success := runtime.Caller(1)
 	if !success {
@@ -0,0 +1,110 @@
+package clog
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Kind classifies an error so that callers can react to it, e.g. pick the
+// response status, without matching error strings.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindInvalidInput
+	KindUnauthorized
+	KindConflict
+	KindUnavailable
+	KindNotImplemented
+	KindMethodNotAllowed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not_found"
+	case KindInvalidInput:
+		return "invalid_input"
+	case KindUnauthorized:
+		return "unauthorized"
+	case KindConflict:
+		return "conflict"
+	case KindUnavailable:
+		return "unavailable"
+	case KindNotImplemented:
+		return "not_implemented"
+	case KindMethodNotAllowed:
+		return "method_not_allowed"
+	}
+	return "internal"
+}
+
+// HTTPStatus is the response status matching the kind.
+func (k Kind) HTTPStatus() int {
+	switch k {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindInvalidInput:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindConflict:
+		return http.StatusConflict
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
+	case KindNotImplemented:
+		return http.StatusNotImplemented
+	case KindMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusInternalServerError
+}
+
+type kindError struct {
+	kind Kind
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func NewKind(kind Kind, msg string) error {
+	return &kindError{kind: kind, err: errors.New(msg)}
+}
+
+func WithKind(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// KindOf returns the outermost kind found in the error chain,
+// KindInternal when there is none.
+func KindOf(err error) Kind {
+	var ke *kindError
+	if errors.As(err, &ke) {
+		return ke.kind
+	}
+	return KindInternal
+}
+
+func IsKind(err error, kind Kind) bool {
+	return err != nil && KindOf(err) == kind
+}
+
+// Message is the part of the error meant for clients: the text of the
+// classified error without the call chain labels around it.
+func Message(err error) string {
+	var ke *kindError
+	if errors.As(err, &ke) {
+		return ke.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,291 @@
+package clog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, ToLog(FuncName(), errors.New("unknown log level <"+s+">"))
+}
+
+// Field is a key/value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+	fields []Field
+}
+
+func New(out io.Writer, level Level, format string) *Logger {
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		level:  level,
+		format: format,
+	}
+}
+
+// With returns a logger adding the fields to every entry.
+func (l *Logger) With(fields ...Field) *Logger {
+	child := *l
+	child.fields = append(append([]Field{}, l.fields...), fields...)
+	return &child
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) { l.write(LevelDebug, msg, fields) }
+func (l *Logger) Info(msg string, fields ...Field)  { l.write(LevelInfo, msg, fields) }
+func (l *Logger) Warn(msg string, fields ...Field)  { l.write(LevelWarn, msg, fields) }
+func (l *Logger) Error(msg string, fields ...Field) { l.write(LevelError, msg, fields) }
+
+func (l *Logger) write(level Level, msg string, fields []Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := make([]Field, 0, len(l.fields)+len(fields)+3)
+	all = append(all,
+		F("time", time.Now().Format(time.RFC3339Nano)),
+		F("level", level.String()),
+		F("msg", msg),
+	)
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+	all = appendRequestID(all)
+
+	var buf bytes.Buffer
+	if l.format == FormatJSON {
+		writeJSON(&buf, all)
+	} else {
+		writeLogfmt(&buf, all)
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// appendRequestID adds the request ID carried by a logged error unless the
+// entry already has one.
+func appendRequestID(fields []Field) []Field {
+	id := ""
+	for _, f := range fields {
+		if f.Key == "request_id" {
+			return fields
+		}
+		if err, ok := f.Value.(error); ok && id == "" {
+			id = RequestIDOf(err)
+		}
+	}
+	if id == "" {
+		return fields
+	}
+	return append(fields, F("request_id", id))
+}
+
+func fieldValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case error:
+		if val == nil {
+			return nil
+		}
+		return val.Error()
+	case time.Duration:
+		return val.String()
+	case fmt.Stringer:
+		return val.String()
+	}
+	return v
+}
+
+func writeJSON(buf *bytes.Buffer, fields []Field) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		enc.Encode(f.Key)
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		if err := enc.Encode(fieldValue(f.Value)); err != nil {
+			enc.Encode(fmt.Sprint(f.Value))
+		}
+		// Encode terminates every value with a newline
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []Field) {
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		var s string
+		switch v := fieldValue(f.Value).(type) {
+		case string:
+			s = v
+		case nil:
+			s = ""
+		default:
+			s = fmt.Sprint(v)
+		}
+		if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+var std atomic.Value
+
+func init() {
+	SetDefault(New(os.Stderr, LevelInfo, FormatLogfmt))
+}
+
+func Default() *Logger {
+	return std.Load().(*Logger)
+}
+
+// SetDefault replaces the package logger and routes the standard library
+// logger through it, so messages of third party packages keep the format.
+func SetDefault(l *Logger) {
+	std.Store(l)
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{})
+}
+
+// the log file opened by Setup, reused while the output stays the same and
+// closed once it is replaced
+var (
+	outputMu   sync.Mutex
+	outputPath string
+	outputFile *os.File
+)
+
+// Setup configures the package logger. Output is stderr, stdout or a file path.
+func Setup(level, format, output string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return ToLog(FuncName(), err)
+	}
+	switch format {
+	case "":
+		format = FormatLogfmt
+	case FormatLogfmt, FormatJSON:
+	default:
+		return ToLog(FuncName(), errors.New("unknown log format <"+format+">"))
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	var out io.Writer
+	var file *os.File
+	switch output {
+	case "", "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	case outputPath:
+		file = outputFile
+		out = file
+	default:
+		file, err = os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return ToLog(FuncName(), err)
+		}
+		out = file
+	}
+	SetDefault(New(out, lvl, format))
+	if outputFile != nil && outputFile != file {
+		outputFile.Close()
+	}
+	outputFile, outputPath = file, ""
+	if file != nil {
+		outputPath = output
+	}
+	return nil
+}
+
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	Default().Info(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+func With(fields ...Field) *Logger      { return Default().With(fields...) }
+func Debug(msg string, fields ...Field) { Default().write(LevelDebug, msg, fields) }
+func Info(msg string, fields ...Field)  { Default().write(LevelInfo, msg, fields) }
+func Warn(msg string, fields ...Field)  { Default().write(LevelWarn, msg, fields) }
+func Error(msg string, fields ...Field) { Default().write(LevelError, msg, fields) }
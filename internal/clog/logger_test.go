@@ -0,0 +1,88 @@
+package clog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo, FormatLogfmt).With(F("agent", "a1"))
+
+	l.Debug("hidden")
+	l.Info("batch sent", F("dest", "127.0.0.1:8080"), F("latency", 1500*time.Microsecond))
+	l.Error("send failed", Err(ToLog("agent.send", errors.New("connection refused"))))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `level=info msg="batch sent" agent=a1 dest=127.0.0.1:8080 latency=1.5ms`)
+	assert.Contains(t, lines[1], `level=error msg="send failed" agent=a1 error="agent.send --> connection refused"`)
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelDebug, FormatJSON)
+	l.Warn("scrape failed", F("target", "http://x"), F("count", 3))
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "scrape failed", entry["msg"])
+	assert.Equal(t, "http://x", entry["target"])
+	assert.Equal(t, float64(3), entry["count"])
+}
+
+func TestParseLevel(t *testing.T) {
+	lvl, err := ParseLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, LevelWarn, lvl)
+	_, err = ParseLevel("loud")
+	assert.Error(t, err)
+}
+
+func TestLoggerRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo, FormatLogfmt)
+	ctx := WithRequestID(context.Background(), "r1")
+	err := ToLog("agent.report", ToLogCtx(ctx, "agent.post", errors.New("503")))
+
+	assert.Equal(t, "r1", RequestIDOf(err))
+	l.Error("report failed", Err(err))
+	assert.Contains(t, buf.String(), `error="agent.report --> agent.post [request r1] --> 503" request_id=r1`)
+}
+
+func TestSetupFile(t *testing.T) {
+	defer Setup("info", FormatLogfmt, "stderr")
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.log"), filepath.Join(dir, "second.log")
+
+	require.NoError(t, Setup("info", FormatLogfmt, first))
+	f := outputFile
+	require.NoError(t, Setup("debug", FormatJSON, first))
+	assert.Same(t, f, outputFile, "the file is not reopened for other settings")
+	Debug("kept")
+
+	require.NoError(t, Setup("info", FormatLogfmt, second))
+	assert.Error(t, f.Close(), "the replaced file is closed")
+	Info("moved")
+
+	require.NoError(t, Setup("info", FormatLogfmt, "stderr"))
+	assert.Nil(t, outputFile)
+
+	data, err := os.ReadFile(first)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"msg":"kept"`)
+	data, err = os.ReadFile(second)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `msg=moved`)
+}
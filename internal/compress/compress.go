@@ -0,0 +1,150 @@
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Gzip     = "gzip"
+	Deflate  = "deflate"
+	Zstd     = "zstd"
+	Brotli   = "br"
+	Identity = "identity"
+)
+
+// Supported lists the encodings in the order the server prefers them when
+// the client gives several the same weight.
+var Supported = [...]string{
+	Zstd,
+	Brotli,
+	Gzip,
+	Deflate,
+}
+
+func IsSupported(enc string) bool {
+	for i := range Supported {
+		if Supported[i] == enc {
+			return true
+		}
+	}
+	return false
+}
+
+func NewWriter(enc string, w io.Writer) (io.WriteCloser, error) {
+	switch enc {
+	case Gzip:
+		gw, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		return gw, nil
+	case Deflate:
+		fw, err := flate.NewWriter(w, flate.BestSpeed)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		return fw, nil
+	case Zstd:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		return zw, nil
+	case Brotli:
+		return brotli.NewWriterLevel(w, brotli.BestSpeed), nil
+	}
+	return nil, clog.ToLog(clog.FuncName(), errors.New("unsupported encoding <"+enc+">"))
+}
+
+func NewReader(enc string, r io.Reader) (io.ReadCloser, error) {
+	switch enc {
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		return gr, nil
+	case Deflate:
+		return flate.NewReader(r), nil
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), err)
+		}
+		return zr.IOReadCloser(), nil
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	}
+	return nil, clog.ToLog(clog.FuncName(), errors.New("unsupported encoding <"+enc+">"))
+}
+
+// Negotiate picks the response encoding for an Accept-Encoding header. It
+// returns an empty string when the body should be sent as is.
+func Negotiate(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok || strings.TrimSpace(k) != "q" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = f
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		weights[name] = q
+	}
+
+	type candidate struct {
+		enc  string
+		q    float64
+		rank int
+	}
+	candidates := []candidate{}
+	for i, enc := range Supported {
+		q, ok := weights[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{enc, q, i})
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].rank < candidates[j].rank
+	})
+	if q, ok := weights[Identity]; ok && q > candidates[0].q {
+		return ""
+	}
+	return candidates[0].enc
+}
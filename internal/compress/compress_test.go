@@ -0,0 +1,50 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br, zstd", Zstd},
+		{"gzip;q=0.5, deflate;q=0.8", Deflate},
+		{"br;q=0, gzip;q=0.1", Gzip},
+		{"*", Zstd},
+		{"*;q=0.5, zstd;q=0", Brotli},
+		{"identity, gzip;q=0.5", ""},
+		{"compress", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Negotiate(tt.header), tt.header)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5}`), 100)
+	for _, enc := range Supported {
+		var buf bytes.Buffer
+		w, err := NewWriter(enc, &buf)
+		require.NoError(t, err, enc)
+		_, err = w.Write(payload)
+		require.NoError(t, err, enc)
+		require.NoError(t, w.Close(), enc)
+		assert.Less(t, buf.Len(), len(payload), enc)
+
+		r, err := NewReader(enc, &buf)
+		require.NoError(t, err, enc)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err, enc)
+		r.Close()
+		assert.Equal(t, payload, got, enc)
+	}
+}
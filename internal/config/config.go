@@ -0,0 +1,289 @@
+// Package config reads configuration files for the server and the agent.
+// File keys are the env variable names of the config fields in lower case,
+// e.g. poll_interval for POLL_INTERVAL, so every setting has the same name
+// in the file and in the environment.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// FormatOf returns the file format by its extension.
+func FormatOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	}
+	return "", clog.ToLog(clog.FuncName(), errors.New("unsupported config file <"+path+">, expected .json, .yaml or .yml"))
+}
+
+type field struct {
+	value reflect.Value
+	sep   string
+}
+
+func fields(cfg interface{}) (map[string]field, []string) {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	res := map[string]field{}
+	keys := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		sep := t.Field(i).Tag.Get("envSeparator")
+		if sep == "" {
+			sep = ","
+		}
+		key := strings.ToLower(name)
+		res[key] = field{value: v.Field(i), sep: sep}
+		keys = append(keys, key)
+	}
+	return res, keys
+}
+
+// Load applies the settings of a JSON or YAML file to cfg, a pointer to a
+// config struct. All problems of the file are reported at once.
+func Load(path string, cfg interface{}) error {
+	format, err := FormatOf(path)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	raw := map[string]interface{}{}
+	if format == FormatJSON {
+		err = json.Unmarshal(data, &raw)
+	} else {
+		err = yaml.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, fmt.Errorf("config file <%s>: %w", path, err)))
+	}
+
+	known, _ := fields(cfg)
+	names := make([]string, 0, len(raw))
+	for k := range raw {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	problems := []string{}
+	for _, k := range names {
+		f, ok := known[strings.ToLower(k)]
+		if !ok {
+			problems = append(problems, "unknown key <"+k+">")
+			continue
+		}
+		if err := set(f, raw[k]); err != nil {
+			problems = append(problems, "key <"+k+">: "+err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindInvalidInput, "config file <"+path+">: "+strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+func scalar(raw interface{}) (string, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("expected a single value, got %T", raw)
+}
+
+func set(f field, raw interface{}) error {
+	v := f.value
+	if v.Type() == durationType {
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration such as \"10s\", got %v", raw)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Slice:
+		items := []string{}
+		switch list := raw.(type) {
+		case []interface{}:
+			for i := range list {
+				s, err := scalar(list[i])
+				if err != nil {
+					return err
+				}
+				items = append(items, s)
+			}
+		default:
+			s, err := scalar(raw)
+			if err != nil {
+				return err
+			}
+			if s != "" {
+				items = strings.Split(s, f.sep)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	case reflect.String:
+		s, err := scalar(raw)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+		return nil
+	}
+
+	s, err := scalar(raw)
+	if err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || n != math.Trunc(n) {
+			return fmt.Errorf("expected an integer, got %q", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", s)
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported setting type %v", v.Type())
+	}
+	return nil
+}
+
+// Write prints cfg in the file format, so that the output can be loaded back.
+func Write(w io.Writer, cfg interface{}, format string) error {
+	known, keys := fields(cfg)
+	out := map[string]interface{}{}
+	for _, k := range keys {
+		v := known[k].value
+		switch {
+		case v.Type() == durationType:
+			out[k] = time.Duration(v.Int()).String()
+		case v.Kind() == reflect.Slice && v.IsNil():
+			out[k] = []string{}
+		default:
+			out[k] = v.Interface()
+		}
+	}
+
+	var data []byte
+	var err error
+	switch format {
+	case FormatJSON:
+		data, err = json.MarshalIndent(out, "", "  ")
+		data = append(data, '\n')
+	case FormatYAML:
+		data, err = yaml.Marshal(out)
+	default:
+		err = errors.New("unsupported config format <" + format + ">")
+	}
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+// Diff returns the keys of the settings that differ between a and b,
+// pointers to config structs of the same type.
+func Diff(a, b interface{}) []string {
+	fa, keys := fields(a)
+	fb, _ := fields(b)
+	res := []string{}
+	for _, k := range keys {
+		if !reflect.DeepEqual(fa[k].value.Interface(), fb[k].value.Interface()) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// Copy sets the settings named by keys in dst to their values in src.
+func Copy(dst, src interface{}, keys []string) {
+	fd, _ := fields(dst)
+	fs, _ := fields(src)
+	for _, k := range keys {
+		if d, ok := fd[k]; ok {
+			d.value.Set(fs[k].value)
+		}
+	}
+}
+
+// Problems collects validation failures to report them together.
+type Problems []string
+
+func (p *Problems) Check(ok bool, msg string) {
+	if !ok {
+		*p = append(*p, msg)
+	}
+}
+
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return clog.NewKind(clog.KindInvalidInput, "invalid config: "+strings.Join(p, "; "))
+}
+
+// ValidAddr reports whether addr has the host:port form.
+func ValidAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port != ""
+}
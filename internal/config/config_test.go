@@ -0,0 +1,86 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Addr     string        `env:"ADDRESS"`
+	Interval time.Duration `env:"POLL_INTERVAL"`
+	Limit    int           `env:"RATE_LIMIT"`
+	Jitter   float64       `env:"RETRY_JITTER"`
+	Restore  bool          `env:"RESTORE"`
+	Labels   []string      `env:"AGENT_LABELS"`
+	Commands []string      `env:"EXEC_COMMANDS" envSeparator:";"`
+
+	Internal bool
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	yamlPath := writeFile(t, "cfg.yaml", `
+address: 127.0.0.1:8080
+poll_interval: 2s
+rate_limit: 4
+retry_jitter: 0.5
+restore: true
+agent_labels: [env=prod, dc=eu]
+exec_commands: "a=echo 1;b=echo 2"
+`)
+	cfg := testConfig{Addr: "default", Internal: true}
+	require.NoError(t, Load(yamlPath, &cfg))
+	assert.Equal(t, testConfig{
+		Addr:     "127.0.0.1:8080",
+		Interval: 2 * time.Second,
+		Limit:    4,
+		Jitter:   0.5,
+		Restore:  true,
+		Labels:   []string{"env=prod", "dc=eu"},
+		Commands: []string{"a=echo 1", "b=echo 2"},
+		Internal: true,
+	}, cfg)
+
+	// the printed config loads back to the same values
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, &cfg, FormatJSON))
+	jsonPath := writeFile(t, "cfg.json", buf.String())
+	loaded := testConfig{Internal: true}
+	require.NoError(t, Load(jsonPath, &loaded))
+	assert.Equal(t, cfg, loaded)
+}
+
+func TestLoadErrors(t *testing.T) {
+	path := writeFile(t, "cfg.json", `{"poll_interval": 5, "rate_limit": 1.5, "colour": "red"}`)
+	err := Load(path, &testConfig{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown key <colour>")
+	assert.Contains(t, err.Error(), "key <poll_interval>: expected a duration")
+	assert.Contains(t, err.Error(), "key <rate_limit>: expected an integer")
+
+	assert.Error(t, Load(writeFile(t, "cfg.toml", ""), &testConfig{}))
+}
+
+func TestDiffCopy(t *testing.T) {
+	a := testConfig{Addr: "localhost:8080", Interval: time.Second, Labels: []string{"a"}, Internal: true}
+	b := testConfig{Addr: "localhost:9090", Interval: time.Second, Labels: []string{"a", "b"}, Limit: 5}
+	assert.Equal(t, []string{"address", "rate_limit", "agent_labels"}, Diff(&a, &b))
+
+	Copy(&a, &b, []string{"rate_limit", "agent_labels"})
+	assert.Equal(t, "localhost:8080", a.Addr)
+	assert.Equal(t, 5, a.Limit)
+	assert.Equal(t, []string{"a", "b"}, a.Labels)
+	assert.True(t, a.Internal)
+	assert.Equal(t, []string{"address"}, Diff(&a, &b))
+}
@@ -1,23 +1,67 @@
 package custom
 
 import (
-	"bufio"
+	"bytes"
 	"strings"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
 )
 
+// CustomSplit splits a batch of comma separated JSON objects into the
+// objects. Whitespace, commas and brackets between them are skipped, an
+// unterminated object at the end is an error.
 func CustomSplit() func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		begin := strings.Index(string(data), "{")
-		end := strings.Index(string(data), "}")
+		begin := bytes.IndexByte(data, '{')
+		end := objectEnd(data, begin)
 		if begin >= 0 && end >= 0 && end > begin {
-			return end + 2, data[begin : end+1], nil
+			advance = end + 1
+			if advance < len(data) && data[advance] == ',' {
+				advance++
+			}
+			return advance, data[begin : end+1], nil
 		}
 
-		if !atEOF || string(data) == "" {
+		if !atEOF || len(data) == 0 {
 			return 0, nil, nil
 		}
-		return len(data), data, clog.ToLog(clog.FuncName(), bufio.ErrFinalToken)
+		if strings.Trim(string(data), " \t\r\n,[]") == "" {
+			return len(data), nil, nil
+		}
+		return 0, nil, clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindInvalidInput, "unterminated object at the end of the batch"))
+	}
+}
+
+// objectEnd returns the index of the brace closing the object that starts
+// at begin, skipping nested objects and braces inside strings.
+func objectEnd(data []byte, begin int) int {
+	if begin < 0 {
+		return -1
+	}
+	depth := 0
+	inString := false
+	for i := begin; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
 	}
+	return -1
 }
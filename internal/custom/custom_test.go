@@ -0,0 +1,83 @@
+package custom
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/stretchr/testify/assert"
+)
+
+func split(input string) ([]string, error) {
+	s := bufio.NewScanner(strings.NewReader(input))
+	s.Split(CustomSplit())
+	res := []string{}
+	for s.Scan() {
+		res = append(res, s.Text())
+	}
+	return res, s.Err()
+}
+
+func TestCustomSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "trailing comma",
+			input: `{"id":"a"},{"id":"b"},`,
+			want:  []string{`{"id":"a"}`, `{"id":"b"}`},
+		},
+		{
+			name:  "array with whitespace",
+			input: "[\n  {\"id\":\"a\"},\n  {\"id\":\"b\"}\n]\n",
+			want:  []string{`{"id":"a"}`, `{"id":"b"}`},
+		},
+		{
+			name:  "nested object",
+			input: `{"id":"a","labels":{"host":"h1"}},{"id":"b"}`,
+			want:  []string{`{"id":"a","labels":{"host":"h1"}}`, `{"id":"b"}`},
+		},
+		{
+			name:  "braces and commas in strings",
+			input: `{"id":"a},{b","labels":{"k":"{,}"}},{"id":"c"}`,
+			want:  []string{`{"id":"a},{b","labels":{"k":"{,}"}}`, `{"id":"c"}`},
+		},
+		{
+			name:  "escaped quotes",
+			input: `{"id":"a\"}","labels":{"k":"\\"}},{"id":"b"}`,
+			want:  []string{`{"id":"a\"}","labels":{"k":"\\"}}`, `{"id":"b"}`},
+		},
+		{
+			name:    "truncated final object",
+			input:   `{"id":"a"},{"id":"b","labels":{"k":"v"}`,
+			want:    []string{`{"id":"a"}`},
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			input:   `{"id":"a}`,
+			want:    []string{},
+			wantErr: true,
+		},
+		{
+			name:  "empty",
+			input: "",
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := split(tt.input)
+			assert.Equal(t, tt.want, got)
+			if tt.wantErr {
+				assert.True(t, clog.IsKind(err, clog.KindInvalidInput), "got %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
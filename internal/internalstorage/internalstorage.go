@@ -4,8 +4,6 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
-	"errors"
-	"log"
 	"os"
 	"sync"
 
@@ -34,10 +32,13 @@ func (st *MetricStorage) GetMetric(name string) (metric.Metric, error) {
 	if m, ok := st.Metrics[name]; ok {
 		return m, nil
 	}
-	return metric.Metric{}, clog.ToLog(clog.FuncName(), errors.New("cannot get: metric <"+name+"> doesn't exist"))
+	return metric.Metric{}, clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindNotFound, "cannot get: metric <"+name+"> doesn't exist"))
 }
 
 func (st *MetricStorage) GetBatch() ([]metric.Metric, error) {
+	st.Lock()
+	defer st.Unlock()
+
 	allMetrics := []metric.Metric{}
 	for k := range st.Metrics {
 		allMetrics = append(allMetrics, st.Metrics[k])
@@ -55,7 +56,17 @@ func (st *MetricStorage) UpdateMetric(m metric.Metric) error {
 	return nil
 }
 
+func conflict(m metric.Metric, mtype string) error {
+	if mtype != "" && m.MType != "" && mtype != m.MType {
+		return clog.NewKind(clog.KindConflict, "cannot update: metric <"+m.ID+"> is <"+mtype+">, not <"+m.MType+">")
+	}
+	return nil
+}
+
 func (st *MetricStorage) updateMetric(m metric.Metric) error {
+	if err := conflict(m, st.Metrics[m.ID].MType); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
 	if m.Delta != nil {
 		if mEx, ok := st.Metrics[m.ID]; ok && mEx.Delta != nil {
 			del := *mEx.Delta + *m.Delta
@@ -66,10 +77,15 @@ func (st *MetricStorage) updateMetric(m metric.Metric) error {
 	return nil
 }
 
+// UpdateBatch applies all metrics or none of them, as the database storage
+// does within a transaction.
 func (st *MetricStorage) UpdateBatch(batch []metric.Metric) error {
 	st.Lock()
 	defer st.Unlock()
 
+	if err := st.checkBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
 	for i := range batch {
 		if err := st.updateMetric(batch[i]); err != nil {
 			return clog.ToLog(clog.FuncName(), err)
@@ -78,9 +94,30 @@ func (st *MetricStorage) UpdateBatch(batch []metric.Metric) error {
 	return nil
 }
 
+// checkBatch finds type conflicts with the stored metrics and within the
+// batch itself before anything is changed.
+func (st *MetricStorage) checkBatch(batch []metric.Metric) error {
+	types := map[string]string{}
+	for i := range batch {
+		m := batch[i]
+		mtype, ok := types[m.ID]
+		if !ok {
+			mtype = st.Metrics[m.ID].MType
+		}
+		if err := conflict(m, mtype); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		if m.MType != "" {
+			mtype = m.MType
+		}
+		types[m.ID] = mtype
+	}
+	return nil
+}
+
 func (st *MetricStorage) AccessCheck(ctx context.Context) error {
 	if st.Metrics == nil {
-		return clog.ToLog(clog.FuncName(), errors.New("storage map is not initialized"))
+		return clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindUnavailable, "storage map is not initialized"))
 	}
 	return nil
 }
@@ -105,7 +142,7 @@ func (st *MetricStorage) UploadStorage() error {
 			return clog.ToLog(clog.FuncName(), err)
 		}
 	}
-	log.Println("UPLOADED TO: " + st.FilePath)
+	clog.Debug("storage uploaded", clog.F("file", st.FilePath), clog.F("metrics", len(st.Metrics)))
 	return nil
 }
 
@@ -128,6 +165,6 @@ func (st *MetricStorage) DownloadStorage() error {
 			return clog.ToLog(clog.FuncName(), err)
 		}
 	}
-	log.Println("DOWNLOADED FROM: " + st.FilePath)
+	clog.Info("storage downloaded", clog.F("file", st.FilePath), clog.F("metrics", len(st.Metrics)))
 	return nil
 }
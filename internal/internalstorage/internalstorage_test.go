@@ -0,0 +1,56 @@
+package internalstorage
+
+import (
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateBatchConflict(t *testing.T) {
+	del, val := int64(5), 1.5
+	st := New("", "")
+	require.NoError(t, st.UpdateMetric(metric.Metric{ID: "c", MType: "counter", Delta: &del}))
+
+	tests := []struct {
+		name  string
+		batch []metric.Metric
+	}{
+		{
+			name: "conflict with stored metric",
+			batch: []metric.Metric{
+				{ID: "g", MType: "gauge", Value: &val},
+				{ID: "c", MType: "counter", Delta: &del},
+				{ID: "c", MType: "gauge", Value: &val},
+				{ID: "h", MType: "gauge", Value: &val},
+			},
+		},
+		{
+			name: "conflict within batch",
+			batch: []metric.Metric{
+				{ID: "c", MType: "counter", Delta: &del},
+				{ID: "n", MType: "gauge", Value: &val},
+				{ID: "n", MType: "counter", Delta: &del},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := st.UpdateBatch(tt.batch)
+			require.Error(t, err)
+			assert.True(t, clog.IsKind(err, clog.KindConflict))
+
+			all, err := st.GetBatch()
+			require.NoError(t, err)
+			require.Len(t, all, 1, "nothing of a rejected batch is stored")
+			assert.Equal(t, int64(5), *all[0].Delta)
+		})
+	}
+
+	require.NoError(t, st.UpdateBatch([]metric.Metric{{ID: "c", MType: "counter", Delta: &del}, {ID: "g", MType: "gauge", Value: &val}}))
+	m, err := st.GetMetric("c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *m.Delta)
+}
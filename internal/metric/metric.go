@@ -6,10 +6,17 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sort"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
 )
 
+const (
+	AgentIDHeader      = "X-Agent-ID"
+	AgentVersionHeader = "X-Agent-Version"
+	RequestIDHeader    = "X-Request-ID"
+)
+
 const Schema = `
 	(
 		mname CHARACTER VARYING PRIMARY KEY,
@@ -34,8 +41,12 @@ type Metric struct {
 	Delta *int64   `json:"delta,omitempty"`
 	Value *float64 `json:"value,omitempty"`
 	Hash  string   `json:"hash,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
+// UpdateHash signs the metric with key. Labels are signed as well, sorted
+// by name; a metric without labels keeps the hash it had before labels.
 func (m *Metric) UpdateHash(key string) error {
 	if key == "" {
 		m.Hash = ""
@@ -50,8 +61,20 @@ func (m *Metric) UpdateHash(key string) error {
 		valuePart = fmt.Sprintf("%s:%s:%f", m.ID, m.MType, *m.Value)
 	}
 
+	var labelsPart string
+	if len(m.Labels) > 0 {
+		names := make([]string, 0, len(m.Labels))
+		for k := range m.Labels {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			labelsPart += fmt.Sprintf(":%q=%q", k, m.Labels[k])
+		}
+	}
+
 	h := hmac.New(sha256.New, []byte(key))
-	_, err := h.Write([]byte(deltaPart + valuePart))
+	_, err := h.Write([]byte(deltaPart + valuePart + labelsPart))
 	if err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
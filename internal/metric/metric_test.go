@@ -0,0 +1,37 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateHashLabels(t *testing.T) {
+	val := 1.5
+	m := Metric{ID: "Alloc", MType: "gauge", Value: &val}
+	require.NoError(t, m.UpdateHash("key"))
+	plain := m.Hash
+	// HMAC-SHA256 of "Alloc:gauge:1.500000", unchanged for metrics without labels
+	assert.Equal(t, "f16b84a15027363b38369ec9e69fcf5f046ac30b535507433f415f82a7706787", plain)
+
+	m.Labels = map[string]string{"host": "h1", "dc": "eu"}
+	require.NoError(t, m.UpdateHash("key"))
+	labeled := m.Hash
+	assert.NotEqual(t, plain, labeled)
+
+	m.Labels = map[string]string{"dc": "eu", "host": "h1"}
+	require.NoError(t, m.UpdateHash("key"))
+	assert.Equal(t, labeled, m.Hash, "label order does not matter")
+
+	m.Labels["host"] = "h2"
+	require.NoError(t, m.UpdateHash("key"))
+	assert.NotEqual(t, labeled, m.Hash, "altered labels break the hash")
+
+	m.Labels = map[string]string{"a": "b,c=d"}
+	require.NoError(t, m.UpdateHash("key"))
+	other := m
+	other.Labels = map[string]string{"a": "b", "c": "d"}
+	require.NoError(t, other.UpdateHash("key"))
+	assert.NotEqual(t, m.Hash, other.Hash)
+}
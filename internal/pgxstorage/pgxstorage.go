@@ -3,7 +3,6 @@ package pgxstorage
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"sync"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
@@ -17,7 +16,8 @@ const (
 	ON CONFLICT (mname)
 	DO
 	UPDATE
-	SET mtype = $2, mval = $3, mdel = metrics.mdel + $4`
+	SET mtype = $2, mval = $3, mdel = metrics.mdel + $4
+	WHERE metrics.mtype = $2`
 
 	stGetMetric = `
 	SELECT * 
@@ -41,11 +41,13 @@ type MetricStorage struct {
 	DB *sql.DB
 }
 
-func New(dbAddr string, drop bool) (*MetricStorage, error) {
+// New connects to the database, maxConns limits the pool, 0 means no limit.
+func New(dbAddr string, maxConns int, drop bool) (*MetricStorage, error) {
 	tmpDB, err := sql.Open("pgx", dbAddr)
 	if err != nil {
 		return nil, clog.ToLog(clog.FuncName(), err)
 	}
+	tmpDB.SetMaxOpenConns(maxConns)
 	ms := &MetricStorage{
 		DB: tmpDB,
 	}
@@ -78,7 +80,7 @@ func (st *MetricStorage) AccessCheck(ctx context.Context) error {
 	defer st.Unlock()
 
 	if err := st.DB.PingContext(ctx); err != nil {
-		return clog.ToLog(clog.FuncName(), err)
+		return clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindUnavailable, err))
 	}
 	return nil
 }
@@ -108,7 +110,7 @@ func (st *MetricStorage) getMetric(name string) (metric.Metric, error) {
 		}
 	}
 	if m.ID == "" {
-		return metric.Metric{}, clog.ToLog(clog.FuncName(), errors.New("cannto get: metric <"+name+"> doesn't exist"))
+		return metric.Metric{}, clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindNotFound, "cannot get: metric <"+name+"> doesn't exist"))
 	}
 	if err := rows.Err(); err != nil {
 		return metric.Metric{}, clog.ToLog(clog.FuncName(), err)
@@ -152,9 +154,26 @@ func (st *MetricStorage) UpdateMetric(m metric.Metric) error {
 }
 
 func (st *MetricStorage) updateMetric(m metric.Metric) error {
-	if _, err := st.DB.Exec(stUpdateMetric, m.ID, m.MType, m.Value, m.Delta); err != nil {
+	res, err := st.DB.Exec(stUpdateMetric, m.ID, m.MType, m.Value, m.Delta)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	if err := checkUpdated(res, m); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+// checkUpdated reports a conflict when the upsert skipped the row because
+// the stored metric has another type.
+func checkUpdated(res sql.Result, m metric.Metric) error {
+	n, err := res.RowsAffected()
+	if err != nil {
 		return clog.ToLog(clog.FuncName(), err)
 	}
+	if n == 0 {
+		return clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindConflict, "cannot update: metric <"+m.ID+"> is not <"+m.MType+">"))
+	}
 	return nil
 }
 
@@ -175,7 +194,11 @@ func (st *MetricStorage) UpdateBatch(batch []metric.Metric) error {
 	defer txStUpdateMetric.Close()
 
 	for i := range batch {
-		if _, err := txStUpdateMetric.Exec(batch[i].ID, batch[i].MType, batch[i].Value, batch[i].Delta); err != nil {
+		res, err := txStUpdateMetric.Exec(batch[i].ID, batch[i].MType, batch[i].Value, batch[i].Delta)
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		if err := checkUpdated(res, batch[i]); err != nil {
 			return clog.ToLog(clog.FuncName(), err)
 		}
 	}
@@ -0,0 +1,234 @@
+package prom
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Name converts a metric ID into a valid Prometheus metric name.
+func Name(id string) string {
+	b := []byte(id)
+	for i := range b {
+		c := b[i]
+		valid := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':' || i > 0 && c >= '0' && c <= '9'
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// WriteText writes metrics in the Prometheus text exposition format, sorted
+// by name. Metrics without a value are skipped.
+func WriteText(w io.Writer, ms []metric.Metric) error {
+	sorted := make([]metric.Metric, len(ms))
+	copy(sorted, ms)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	bw := bufio.NewWriter(w)
+	lastName := ""
+	for i := range sorted {
+		m := sorted[i]
+		var val string
+		switch {
+		case m.MType == "gauge" && m.Value != nil:
+			val = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+		case m.MType == "counter" && m.Delta != nil:
+			val = strconv.FormatInt(*m.Delta, 10)
+		default:
+			continue
+		}
+		name := Name(m.ID)
+		sample := Sample{Name: name}
+		for k, v := range m.Labels {
+			sample.Labels = append(sample.Labels, Label{Name: Name(k), Value: v})
+		}
+		sort.Slice(sample.Labels, func(i, j int) bool { return sample.Labels[i].Name < sample.Labels[j].Name })
+		// series of one family share a single TYPE line
+		if name != lastName {
+			if _, err := bw.WriteString("# TYPE " + name + " " + m.MType + "\n"); err != nil {
+				return clog.ToLog(clog.FuncName(), err)
+			}
+			lastName = name
+		}
+		if _, err := bw.WriteString(sample.ID() + " " + val + "\n"); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Name   string
+	Labels []Label
+	Type   string
+	Value  float64
+}
+
+// ID returns the sample name with its labels sorted by name, in the form
+// name{a="x",b="y"}, which is used as the metric ID in the store.
+func (s Sample) ID() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+	var sb strings.Builder
+	sb.WriteString(s.Name)
+	sb.WriteByte('{')
+	for i := range s.Labels {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(s.Labels[i].Name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(s.Labels[i].Value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Parse reads the Prometheus text exposition format. Samples of histogram
+// and summary families are skipped, untyped samples are reported as gauges.
+func Parse(r io.Reader) ([]Sample, error) {
+	types := map[string]string{}
+	samples := []Sample{}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			fields := strings.Fields(text)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		sample, err := parseSample(text)
+		if err != nil {
+			return nil, clog.ToLog(clog.FuncName(), errors.New("line "+strconv.Itoa(line)+": "+err.Error()))
+		}
+		sample.Type = familyType(types, sample.Name)
+		switch sample.Type {
+		case "counter", "gauge":
+		case "untyped":
+			sample.Type = "gauge"
+		default:
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if err := s.Err(); err != nil {
+		return nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return samples, nil
+}
+
+func familyType(types map[string]string, name string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+	for _, suffix := range [...]string{"_bucket", "_sum", "_count", "_total", "_created"} {
+		if base := strings.TrimSuffix(name, suffix); base != name {
+			if t, ok := types[base]; ok {
+				return t
+			}
+		}
+	}
+	return "untyped"
+}
+
+func parseSample(text string) (Sample, error) {
+	sample := Sample{}
+	n := strings.IndexAny(text, "{ \t")
+	if n <= 0 {
+		return sample, clog.ToLog(clog.FuncName(), errors.New("missing value"))
+	}
+	sample.Name = text[:n]
+	rest := text[n:]
+	if rest[0] == '{' {
+		labels, tail, err := parseLabels(rest[1:])
+		if err != nil {
+			return sample, clog.ToLog(clog.FuncName(), err)
+		}
+		sample.Labels = labels
+		rest = tail
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, clog.ToLog(clog.FuncName(), errors.New("invalid sample <"+text+">"))
+	}
+	val, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, clog.ToLog(clog.FuncName(), err)
+	}
+	sample.Value = val
+	return sample, nil
+}
+
+func parseLabels(s string) ([]Label, string, error) {
+	labels := []Label{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if strings.HasPrefix(s, "}") {
+			sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+			return labels, s[1:], nil
+		}
+		eq := strings.Index(s, "=")
+		if eq <= 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, "", clog.ToLog(clog.FuncName(), errors.New("invalid labels"))
+		}
+		l := Label{Name: strings.TrimSpace(s[:eq])}
+		s = s[eq+2:]
+		var sb strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				default:
+					sb.WriteByte(s[i])
+				}
+				continue
+			}
+			if c == '"' {
+				s = s[i+1:]
+				closed = true
+				break
+			}
+			sb.WriteByte(c)
+		}
+		if !closed {
+			return nil, "", clog.ToLog(clog.FuncName(), errors.New("unterminated label value"))
+		}
+		l.Value = sb.String()
+		labels = append(labels, l)
+	}
+}
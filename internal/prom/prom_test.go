@@ -0,0 +1,52 @@
+package prom
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	text := `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{path="/a\"b",code="200"} 1027 1395066363000
+http_requests_total{code="500",path="/"} 3
+# TYPE temperature gauge
+temperature 21.5
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.05
+rpc_duration_seconds_count 10
+untyped_thing 7
+`
+	samples, err := Parse(strings.NewReader(text))
+	assert.NoError(t, err)
+	ids := []string{}
+	for i := range samples {
+		ids = append(ids, samples[i].Type+" "+samples[i].ID())
+	}
+	assert.Equal(t, []string{
+		`counter http_requests_total{code="200",path="/a\"b"}`,
+		`counter http_requests_total{code="500",path="/"}`,
+		`gauge temperature`,
+		`gauge untyped_thing`,
+	}, ids)
+	assert.Equal(t, 1027.0, samples[0].Value)
+
+	_, err = Parse(strings.NewReader(`broken{a="b} 1`))
+	assert.Error(t, err)
+}
+
+func TestWriteText(t *testing.T) {
+	val := 1.5
+	del := int64(3)
+	var buf bytes.Buffer
+	assert.NoError(t, WriteText(&buf, []metric.Metric{
+		{ID: "b.count", MType: "counter", Delta: &del},
+		{ID: "a", MType: "gauge", Value: &val},
+		{ID: "empty", MType: "gauge"},
+	}))
+	assert.Equal(t, "# TYPE a gauge\na 1.5\n# TYPE b_count counter\nb_count 3\n", buf.String())
+}
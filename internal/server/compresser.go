@@ -1,62 +1,201 @@
 package server
 
 import (
-	"compress/gzip"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/compress"
 )
 
-type customWriter struct {
+// bodies shorter than this are not worth the compression overhead
+const minCompressSize = 512
+
+var incompressibleTypes = [...]string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/octet-stream",
+}
+
+// compressWriter buffers the beginning of the response to decide whether it
+// is worth compressing, based on its size, content type and status.
+type compressWriter struct {
 	http.ResponseWriter
-	Writer io.Writer
+	encoding string
+
+	buf         []byte
+	status      int
+	decided     bool
+	compressor  io.WriteCloser
+	writeFailed error
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	w.status = status
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.compressor != nil {
+			return w.compressor.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= minCompressSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) decide() error {
+	w.decided = true
+	h := w.ResponseWriter.Header()
+	if w.shouldCompress() {
+		compressor, err := compress.NewWriter(w.encoding, w.ResponseWriter)
+		if err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		w.compressor = compressor
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.compressor != nil {
+		_, err := w.compressor.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) shouldCompress() bool {
+	if len(w.buf) < minCompressSize {
+		return false
+	}
+	if w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	h := w.ResponseWriter.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(w.buf)
+	}
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(ct, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	if w.compressor != nil {
+		if err := w.compressor.Close(); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	return nil
+}
+
+// bodyLimiter fails the read once the body grows over the limit, so that a
+// small compressed body cannot expand without bound.
+type bodyLimiter struct {
+	io.ReadCloser
+	left  int64
+	limit int64
 }
 
-func (w customWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+func (b *bodyLimiter) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// a body of exactly the limit is fine, anything past it is not
+		var one [1]byte
+		if n, err := b.ReadCloser.Read(one[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, clog.NewKind(clog.KindInvalidInput, "request body exceeds "+strconv.FormatInt(b.limit, 10)+" bytes")
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
 }
 
-func Compresser(handler http.Handler) http.Handler {
+// Compresser decodes request bodies and compresses responses. Decoded bodies
+// are limited by MaxBodySize.
+func (srv *ServerConfig) Compresser(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Content-Encoding") == "gzip" {
-			gzipReader, err := gzip.NewReader(r.Body)
-			if err != nil {
-				err := clog.ToLog(clog.FuncName(), err)
-				log.Println(err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+		if ce := r.Header.Get("Content-Encoding"); ce != "" && ce != compress.Identity {
+			// encodings are listed in the order they were applied
+			encs := strings.Split(ce, ",")
+			for i := len(encs) - 1; i >= 0; i-- {
+				enc := strings.ToLower(strings.TrimSpace(encs[i]))
+				if enc == compress.Identity {
+					continue
+				}
+				if !compress.IsSupported(enc) {
+					writeError(w, r, clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindInvalidInput, "unsupported content encoding <"+enc+">")))
+					return
+				}
+				reader, err := compress.NewReader(enc, r.Body)
+				if err != nil {
+					writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)))
+					return
+				}
+				defer reader.Close()
+				r.Body = reader
 			}
-			defer gzipReader.Close()
-			r.Body = gzipReader
+			r.Header.Del("Content-Encoding")
+		}
+		if limit := srv.cfg().MaxBodySize; limit > 0 {
+			r.Body = &bodyLimiter{ReadCloser: r.Body, left: limit, limit: limit}
 		}
 
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := compress.Negotiate(r.Header.Get("Accept-Encoding"))
+		if enc == "" {
 			handler.ServeHTTP(w, r)
 			return
 		}
-		gzipWriter, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-		if err != nil {
-			err := clog.ToLog(clog.FuncName(), err)
-			log.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       enc,
 		}
-		defer gzipWriter.Close()
-		w.Header().Set("Content-Encoding", "gzip")
-		handler.ServeHTTP(customWriter{ResponseWriter: w, Writer: gzipWriter}, r)
+		defer func() {
+			if err := cw.Close(); err != nil {
+				logRequestError(r, clog.ToLog(clog.FuncName(), err), http.StatusInternalServerError)
+			}
+		}()
+		handler.ServeHTTP(cw, r)
 	})
 }
-
-/*
-func contains(arr []string, s string) bool {
-	for i := range arr {
-		if arr[i] == s {
-			return true
-		}
-	}
-	return false
-}
-*/
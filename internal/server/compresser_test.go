@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/compress"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompresserBodyLimit(t *testing.T) {
+	gzipped := func(size int) []byte {
+		var buf bytes.Buffer
+		w, err := compress.NewWriter("gzip", &buf)
+		require.NoError(t, err)
+		_, err = w.Write(bytes.Repeat([]byte{' '}, size))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		limit    int64
+		encoding string
+		body     []byte
+		status   int
+		wantLen  int
+	}{
+		{"under the limit", 1024, "gzip", gzipped(512), http.StatusOK, 512},
+		{"exactly the limit", 1024, "gzip", gzipped(1024), http.StatusOK, 1024},
+		{"compressed over the limit", 1024, "gzip", gzipped(1 << 20), http.StatusBadRequest, 0},
+		{"plain over the limit", 1024, "", bytes.Repeat([]byte{' '}, 2048), http.StatusBadRequest, 0},
+		{"no limit", 0, "gzip", gzipped(1 << 20), http.StatusOK, 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &ServerConfig{Cfg: EnvConfig{MaxBodySize: tt.limit}}
+			read := 0
+			handler := srv.Compresser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
+				read = len(body)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.wantLen, read)
+		})
+	}
+}
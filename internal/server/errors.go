@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/go-chi/chi/v5"
+)
+
+// errorBody is the response of every failed request.
+type errorBody struct {
+	Error     string `json:"error"`
+	Kind      string `json:"kind"`
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError logs the error and responds with the status of its kind.
+func writeError(w http.ResponseWriter, r *http.Request, err error, fields ...clog.Field) {
+	kind := clog.KindOf(err)
+	status := kind.HTTPStatus()
+	logRequestError(r, err, status, fields...)
+
+	body, mErr := json.Marshal(errorBody{
+		Error:     clog.Message(err),
+		Kind:      kind.String(),
+		Status:    status,
+		RequestID: clog.RequestID(r.Context()),
+	})
+	if mErr != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", JSONCT)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func logRequestError(r *http.Request, err error, status int, fields ...clog.Field) {
+	route := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+	fields = append([]clog.Field{
+		clog.F("method", r.Method),
+		clog.F("route", route),
+		clog.F("status", status),
+		clog.F("agent", r.Header.Get(metric.AgentIDHeader)),
+	}, fields...)
+	fields = append(fields, clog.Err(err))
+	logger := clog.FromContext(r.Context())
+	if status >= http.StatusInternalServerError {
+		logger.Error("request failed", fields...)
+		return
+	}
+	logger.Warn("request rejected", fields...)
+}
+
+func handlerNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, clog.NewKind(clog.KindNotFound, "no route <"+r.Method+" "+r.URL.Path+">"))
+}
+
+func handlerMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, clog.NewKind(clog.KindMethodNotAllowed, "method <"+r.Method+"> is not allowed on <"+r.URL.Path+">"))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorResponses(t *testing.T) {
+	srv := &ServerConfig{
+		Storage: internalstorage.New("", ""),
+		agents:  newAgentRegistry(),
+	}
+	router := chi.NewRouter()
+	router.NotFound(handlerNotFound)
+	router.MethodNotAllowed(handlerMethodNotAllowed)
+	router.Get("/value/{type}/{name}", srv.handlerGetMetric)
+	router.Post("/value/", srv.handlerGetMetricJSON)
+	router.Post("/update/", srv.handlerUpdateJSON)
+	router.Post("/update/{type}/{name}/{val}", srv.handlerUpdateDirect)
+	router.Post("/updates/", srv.handlerUpdateBatch)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		kind   string
+	}{
+		{"missing metric", http.MethodGet, "/value/gauge/Nope", "", http.StatusNotFound, "not_found"},
+		{"bad json on update", http.MethodPost, "/update/", "{", http.StatusBadRequest, "invalid_input"},
+		{"bad json on value", http.MethodPost, "/value/", "{", http.StatusBadRequest, "invalid_input"},
+		{"bad value", http.MethodPost, "/update/gauge/A/abc", "", http.StatusBadRequest, "invalid_input"},
+		{"unsupported type", http.MethodPost, "/update/hist/A/1", "", http.StatusNotImplemented, "not_implemented"},
+		{"type conflict", http.MethodPost, "/update/counter/G/1", "", http.StatusConflict, "conflict"},
+		{"truncated batch", http.MethodPost, "/updates/", `{"id":"B","type":"gauge","value":1},{"id":"C"`, http.StatusBadRequest, "invalid_input"},
+		{"unknown route", http.MethodGet, "/nowhere", "", http.StatusNotFound, "not_found"},
+		{"wrong method", http.MethodGet, "/update/", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/update/gauge/G/1.5", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, JSONCT, rec.Header().Get("Content-Type"))
+			body := errorBody{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tt.kind, body.Kind)
+			assert.Equal(t, tt.status, body.Status)
+			assert.NotEmpty(t, body.Error)
+		})
+	}
+}
@@ -3,10 +3,7 @@ package server
 import (
 	"bufio"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"strconv"
 	"text/template"
@@ -36,16 +33,12 @@ const (
 
 func (srv *ServerConfig) handlerCheckDBConnection(w http.ResponseWriter, r *http.Request) {
 	if err := srv.Storage.AccessCheck(r.Context()); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 	_, err := w.Write([]byte("STORAGE IS AVAILABLE"))
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 }
@@ -57,89 +50,84 @@ func (srv *ServerConfig) handlerUpdateBatch(w http.ResponseWriter, r *http.Reque
 	for scanner.Scan() {
 		m := metric.Metric{}
 		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
-			err := clog.ToLog(clog.FuncName(), err)
-			log.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)))
 			return
 		}
 
 		if _, err := srv.checkHash(m); err != nil {
-			err := clog.ToLog(clog.FuncName(), err)
-			log.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, clog.ToLog(clog.FuncName(), err))
+			return
+		}
+		if err := checkReservedID(m.ID); err != nil {
+			writeError(w, r, clog.ToLog(clog.FuncName(), err))
 			return
 		}
 		m.Hash = ""
 		batch = append(batch, m)
 	}
+	if err := scanner.Err(); err != nil {
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)))
+		return
+	}
+	labeled := stripLabels(batch)
 	if err := srv.Storage.UpdateBatch(batch); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
+	srv.agents.observe(r.Header, r.RemoteAddr, labeled)
+	srv.self.observeBatch(len(batch), true)
 
-	if srv.Cfg.SyncUpload != nil {
-		var tmp struct{}
-		srv.Cfg.SyncUpload <- tmp
-	}
+	srv.syncUpload()
 }
 
 func (srv *ServerConfig) handlerUpdateJSON(w http.ResponseWriter, r *http.Request) {
 	mj, err := io.ReadAll(r.Body)
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)))
 		return
 	}
 
 	m := metric.Metric{}
 	if err := json.Unmarshal(mj, &m); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)))
 		return
 	}
 
-	if r.Header.Get("Hash") != "" && srv.Cfg.HashKey != "" {
+	if r.Header.Get("Hash") != "" && srv.cfg().HashKey != "" {
 		resHash, err := srv.checkHash(m)
 		w.Header().Set("Hash", resHash)
 		if err != nil {
-			err := clog.ToLog(clog.FuncName(), err)
-			log.Println(err.Error())
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, r, clog.ToLog(clog.FuncName(), err))
 			return
 		}
 	}
 	m.Hash = ""
 
+	if err := checkReservedID(m.ID); err != nil {
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
+		return
+	}
 	if err := checkTypeSupport(m.MType); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 
+	labeled := m
+	m.Labels = nil
 	if err := srv.Storage.UpdateMetric(m); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
+	srv.agents.observe(r.Header, r.RemoteAddr, []metric.Metric{labeled})
+	srv.self.observeBatch(1, false)
 
-	if srv.Cfg.SyncUpload != nil {
-		var tmp struct{}
-		srv.Cfg.SyncUpload <- tmp
-	}
+	srv.syncUpload()
 }
 
 func (srv *ServerConfig) handlerUpdateDirect(w http.ResponseWriter, r *http.Request) {
 	mType := chi.URLParam(r, "type")
 	if err := checkTypeSupport(mType); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 
@@ -154,13 +142,15 @@ func (srv *ServerConfig) handlerUpdateDirect(w http.ResponseWriter, r *http.Requ
 		mDelta, err = strconv.ParseInt(mVal, 10, 64)
 	}
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)), clog.F("metric", chi.URLParam(r, "name")))
 		return
 	}
 
 	mName := chi.URLParam(r, "name")
+	if err := checkReservedID(mName); err != nil {
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
+		return
+	}
 	m := metric.Metric{
 		ID:    mName,
 		MType: mType,
@@ -168,32 +158,25 @@ func (srv *ServerConfig) handlerUpdateDirect(w http.ResponseWriter, r *http.Requ
 		Delta: &mDelta,
 	}
 	if err := srv.Storage.UpdateMetric(m); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
+	srv.agents.observe(r.Header, r.RemoteAddr, []metric.Metric{m})
+	srv.self.observeBatch(1, false)
 
-	if srv.Cfg.SyncUpload != nil {
-		var tmp struct{}
-		srv.Cfg.SyncUpload <- tmp
-	}
+	srv.syncUpload()
 }
 
 func (srv *ServerConfig) handlerGetAll(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	t, err := template.New("").Parse(templateHandlerGetAll)
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 	allMetrics, err := srv.Storage.GetBatch()
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 	t.Execute(w, allMetrics)
@@ -202,51 +185,39 @@ func (srv *ServerConfig) handlerGetAll(w http.ResponseWriter, r *http.Request) {
 func (srv *ServerConfig) handlerGetMetricJSON(w http.ResponseWriter, r *http.Request) {
 	mjReq, err := io.ReadAll(r.Body)
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)))
 		return
 	}
 
 	mReq := metric.Metric{}
 	if err := json.Unmarshal(mjReq, &mReq); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.WithKind(clog.KindInvalidInput, err)))
 		return
 	}
 
 	if err := checkTypeSupport(mReq.MType); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 
 	mRes, err := srv.Storage.GetMetric(mReq.ID)
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err), clog.F("metric", mReq.ID))
 		return
 	}
 	if mRes.MType != mReq.MType {
-		http.Error(w, "cannot get: metric <"+mReq.ID+"> is not <"+mReq.MType+">", http.StatusNotFound)
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindNotFound, "cannot get: metric <"+mReq.ID+"> is not <"+mReq.MType+">")), clog.F("metric", mReq.ID))
 		return
 	}
 
-	if err := mRes.UpdateHash(srv.Cfg.HashKey); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := mRes.UpdateHash(srv.cfg().HashKey); err != nil {
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 
 	mjRes, err := json.Marshal(mRes)
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 	w.Header().Set("Content-Type", JSONCT)
@@ -256,24 +227,18 @@ func (srv *ServerConfig) handlerGetMetricJSON(w http.ResponseWriter, r *http.Req
 func (srv *ServerConfig) handlerGetMetric(w http.ResponseWriter, r *http.Request) {
 	mType := chi.URLParam(r, "type")
 	if err := checkTypeSupport(mType); err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotImplemented)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 
 	mName := chi.URLParam(r, "name")
 	m, err := srv.Storage.GetMetric(mName)
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err), clog.F("metric", mName))
 		return
 	}
 	if m.MType != mType {
-		err := clog.ToLog(clog.FuncName(), errors.New("cannot get: metric <"+mName+"> is not <"+mType+">"))
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindNotFound, "cannot get: metric <"+mName+"> is not <"+mType+">")), clog.F("metric", mName))
 		return
 	}
 
@@ -284,29 +249,39 @@ func (srv *ServerConfig) handlerGetMetric(w http.ResponseWriter, r *http.Request
 		_, err = w.Write([]byte(strconv.FormatInt(*m.Delta, 10)))
 	}
 	if err != nil {
-		err := clog.ToLog(clog.FuncName(), err)
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
 		return
 	}
 }
 
+// stripLabels removes agent labels from the metrics before they are stored
+// and returns a copy that still carries them for the agent registry.
+func stripLabels(batch []metric.Metric) []metric.Metric {
+	labeled := make([]metric.Metric, len(batch))
+	copy(labeled, batch)
+	for i := range batch {
+		batch[i].Labels = nil
+	}
+	return labeled
+}
+
 func checkTypeSupport(mType string) error {
 	for i := range supportedTypes {
 		if mType == supportedTypes[i] {
 			return nil
 		}
 	}
-	return clog.ToLog(clog.FuncName(), errors.New("unsupported type <"+mType+">"))
+	return clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindNotImplemented, "unsupported type <"+mType+">"))
 }
 
 func (srv *ServerConfig) checkHash(m metric.Metric) (string, error) {
 	tmp := m
 	h := m.Hash
-	m.UpdateHash(srv.Cfg.HashKey)
+	m.UpdateHash(srv.cfg().HashKey)
 	if h != m.Hash {
-		fmt.Println(m, tmp)
-		return m.Hash, clog.ToLog(clog.FuncName(), errors.New("inconsistent hashes"))
+		srv.self.add("hash_failures_total", nil, 1)
+		clog.Debug("inconsistent hashes", clog.F("metric", m.ID), clog.F("got", tmp.Hash), clog.F("want", m.Hash))
+		return m.Hash, clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindUnauthorized, "inconsistent hashes"))
 	}
 	return m.Hash, nil
 }
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+)
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// healthState tracks what the readiness checks need beyond the storage.
+type healthState struct {
+	mu          sync.Mutex
+	started     time.Time
+	fileStorage bool
+	lastUpload  time.Time
+	uploadErr   error
+}
+
+func newHealthState() *healthState {
+	return &healthState{started: time.Now()}
+}
+
+func (hs *healthState) observeUpload(err error) {
+	if hs == nil {
+		return
+	}
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.uploadErr = err
+	if err == nil {
+		hs.lastUpload = time.Now()
+	}
+}
+
+type checkResult struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+	Latency string `json:"latency,omitempty"`
+}
+
+type readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func failed(err error) checkResult {
+	return checkResult{Status: checkFail, Error: err.Error()}
+}
+
+func (srv *ServerConfig) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if srv.health != nil {
+		started = srv.health.started
+	}
+	writeJSON(w, r, http.StatusOK, map[string]string{
+		"status": checkOK,
+		"uptime": time.Since(started).Round(time.Second).String(),
+	})
+}
+
+func (srv *ServerConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	res := readiness{
+		Status: "ready",
+		Checks: map[string]checkResult{
+			"storage": srv.checkStorage(r.Context()),
+		},
+	}
+	if srv.health != nil && srv.health.fileStorage {
+		res.Checks["upload"] = srv.checkUpload()
+	}
+	if srv.self != nil && srv.self.dbStats != nil {
+		res.Checks["db_pool"] = srv.checkPool()
+	}
+
+	status := http.StatusOK
+	for name, c := range res.Checks {
+		if c.Status != checkOK {
+			res.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+			clog.FromContext(r.Context()).Warn("readiness check failed", clog.F("check", name), clog.F("error", c.Error))
+		}
+	}
+	writeJSON(w, r, status, res)
+}
+
+func (srv *ServerConfig) checkStorage(ctx context.Context) checkResult {
+	if srv.Storage == nil {
+		return failed(errors.New("storage is not configured"))
+	}
+	if timeout := srv.cfg().ReadyTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := srv.Storage.AccessCheck(ctx)
+	res := checkResult{Status: checkOK, Latency: time.Since(start).String()}
+	if err != nil {
+		res.Status, res.Error = checkFail, clog.Message(err)
+	}
+	return res
+}
+
+// checkUpload fails when the last upload failed or, with periodic uploads,
+// when no upload succeeded for longer than the allowed age.
+func (srv *ServerConfig) checkUpload() checkResult {
+	hs := srv.health
+	hs.mu.Lock()
+	last, uploadErr, started := hs.lastUpload, hs.uploadErr, hs.started
+	hs.mu.Unlock()
+
+	if uploadErr != nil {
+		return failed(uploadErr)
+	}
+	res := checkResult{Status: checkOK, Detail: "no upload yet"}
+	if !last.IsZero() {
+		res.Detail = "last upload " + time.Since(last).Round(time.Millisecond).String() + " ago"
+	} else {
+		last = started
+	}
+	cfg := srv.cfg()
+	maxAge := cfg.ReadyUploadAge
+	if maxAge == 0 {
+		maxAge = 3 * cfg.StoreInterval
+	}
+	if maxAge > 0 && time.Since(last) > maxAge {
+		res.Status = checkFail
+		res.Error = "no successful upload for more than " + maxAge.String()
+	}
+	return res
+}
+
+func (srv *ServerConfig) checkPool() checkResult {
+	st := srv.self.dbStats()
+	res := checkResult{
+		Status: checkOK,
+		Detail: strconv.Itoa(st.InUse) + " of " + strconv.Itoa(st.MaxOpenConnections) + " connections in use",
+	}
+	if st.MaxOpenConnections == 0 {
+		res.Detail = strconv.Itoa(st.InUse) + " connections in use, no limit"
+		return res
+	}
+	saturation := float64(st.InUse) / float64(st.MaxOpenConnections)
+	limit := srv.cfg().ReadyPoolSaturation
+	if limit > 0 && saturation >= limit {
+		res.Status = checkFail
+		res.Error = "pool saturation " + strconv.FormatFloat(saturation, 'f', 2, 64) + " exceeds " + strconv.FormatFloat(limit, 'f', 2, 64)
+	}
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
+		return
+	}
+	w.Header().Set("Content-Type", JSONCT)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadyz(t *testing.T) {
+	srv := &ServerConfig{
+		Storage: internalstorage.New("", ""),
+		Cfg:     EnvConfig{StoreInterval: time.Minute},
+		health:  newHealthState(),
+	}
+	srv.health.fileStorage = true
+
+	get := func() (int, readiness) {
+		rec := httptest.NewRecorder()
+		srv.handlerReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		res := readiness{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return rec.Code, res
+	}
+
+	code, res := get()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", res.Status)
+	assert.Equal(t, checkOK, res.Checks["storage"].Status)
+	assert.Equal(t, checkOK, res.Checks["upload"].Status)
+
+	srv.health.observeUpload(errors.New("disk full"))
+	code, res = get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", res.Status)
+	assert.Equal(t, "disk full", res.Checks["upload"].Error)
+
+	// a stale upload is as bad as a failed one
+	srv.health.observeUpload(nil)
+	srv.health.lastUpload = time.Now().Add(-time.Hour)
+	code, res = get()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, checkFail, res.Checks["upload"].Status)
+}
+
+func TestCheckPool(t *testing.T) {
+	stats := sql.DBStats{MaxOpenConnections: 10, InUse: 5}
+	srv := &ServerConfig{
+		Cfg:  EnvConfig{ReadyPoolSaturation: 0.9},
+		self: newSelfMetrics(),
+	}
+	srv.self.dbStats = func() sql.DBStats { return stats }
+
+	res := srv.checkPool()
+	assert.Equal(t, checkOK, res.Status)
+	assert.Equal(t, "5 of 10 connections in use", res.Detail)
+
+	stats.InUse = 9
+	res = srv.checkPool()
+	assert.Equal(t, checkFail, res.Status)
+	assert.Equal(t, "pool saturation 0.90 exceeds 0.90", res.Error)
+
+	stats.MaxOpenConnections = 0
+	assert.Equal(t, checkOK, srv.checkPool().Status)
+}
+
+func TestOpenStorageBadDatabase(t *testing.T) {
+	srv := &ServerConfig{
+		Cfg:    EnvConfig{DBAddr: "postgres://user@127.0.0.1:1/metrics?connect_timeout=1"},
+		self:   newSelfMetrics(),
+		health: newHealthState(),
+	}
+	fileStorage, closeStorage := srv.openStorage()
+	defer closeStorage()
+	assert.Nil(t, fileStorage)
+	assert.Nil(t, srv.self.dbStats)
+
+	rec := httptest.NewRecorder()
+	srv.handlerReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	res := readiness{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, checkFail, res.Checks["storage"].Status)
+	assert.Contains(t, res.Checks["storage"].Error, "storage is unavailable")
+
+	rec = httptest.NewRecorder()
+	srv.handlerCheckDBConnection(rec, httptest.NewRequest(http.MethodGet, "/ping/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+const maxRequestIDLen = 128
+
+// RequestID keeps the X-Request-ID of the client, or assigns a new one, and
+// puts it into the request context and the response headers.
+func RequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(metric.RequestIDHeader)
+		if !validRequestID(id) {
+			id = clog.NewRequestID()
+			r.Header.Set(metric.RequestIDHeader, id)
+		}
+		w.Header().Set(metric.RequestIDHeader, id)
+		handler.ServeHTTP(w, r.WithContext(clog.WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// AccessLog logs every request once it is served. Bytes are counted as they
+// leave the server, after compression.
+func AccessLog(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logger := clog.FromContext(r.Context())
+		log := logger.Info
+		// orchestrator probes would flood the log
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			log = logger.Debug
+		}
+		log("request",
+			clog.F("method", r.Method),
+			clog.F("path", r.URL.Path),
+			clog.F("status", rec.status),
+			clog.F("bytes", rec.bytes),
+			clog.F("latency", time.Since(start)),
+			clog.F("client", r.RemoteAddr),
+			clog.F("agent", r.Header.Get(metric.AgentIDHeader)),
+		)
+	})
+}
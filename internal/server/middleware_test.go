@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		keep bool
+	}{
+		{"valid id is kept", "agent-1.7f3a", true},
+		{"max length is kept", strings.Repeat("a", maxRequestIDLen), true},
+		{"missing id", "", false},
+		{"oversized id", strings.Repeat("a", maxRequestIDLen+1), false},
+		{"space", "a b", false},
+		{"control character", "a\x01b", false},
+		{"non ascii", "idé", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen, seenHeader string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = clog.RequestID(r.Context())
+				seenHeader = r.Header.Get(metric.RequestIDHeader)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(metric.RequestIDHeader, tt.id)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(metric.RequestIDHeader)
+			assert.Equal(t, got, seen, "the context carries the response id")
+			assert.Equal(t, got, seenHeader, "handlers see the id in the header")
+			if tt.keep {
+				assert.Equal(t, tt.id, got)
+			} else {
+				assert.NotEqual(t, tt.id, got)
+				assert.True(t, validRequestID(got), got)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	prev := clog.Default()
+	clog.SetDefault(clog.New(&buf, clog.LevelInfo, clog.FormatJSON))
+	defer clog.SetDefault(prev)
+
+	router := http.NewServeMux()
+	router.HandleFunc("/value/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("missing"))
+	})
+	router.HandleFunc("/update/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+		w.Write([]byte("!"))
+	})
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	handler := RequestID(AccessLog(router))
+
+	for _, path := range []string{"/value/", "/update/", "/healthz"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set(metric.RequestIDHeader, "req-"+strings.Trim(path, "/"))
+		req.Header.Set(metric.AgentIDHeader, "a1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2, "probes are logged at debug level")
+	entries := make([]map[string]interface{}, len(lines))
+	for i := range lines {
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &entries[i]))
+	}
+
+	assert.Equal(t, "/value/", entries[0]["path"])
+	assert.Equal(t, float64(http.StatusNotFound), entries[0]["status"])
+	assert.Equal(t, float64(len("missing")), entries[0]["bytes"])
+	assert.Equal(t, "req-value", entries[0]["request_id"])
+	assert.Equal(t, "a1", entries[0]["agent"])
+
+	assert.Equal(t, "/update/", entries[1]["path"])
+	assert.Equal(t, float64(http.StatusOK), entries[1]["status"], "an implicit status is 200")
+	assert.Equal(t, float64(3), entries[1]["bytes"], "bytes of all writes add up")
+	assert.Equal(t, http.MethodPost, entries[1]["method"])
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+)
+
+type agentInfo struct {
+	ID        string            `json:"id"`
+	Version   string            `json:"version,omitempty"`
+	Addr      string            `json:"addr,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+	Reported  int64             `json:"reported"`
+	Metrics   int               `json:"metrics"`
+}
+
+// agentRegistry tracks the agents that identify themselves in update
+// requests: when they were seen, how many metric updates they sent and how
+// many distinct metrics those covered.
+type agentRegistry struct {
+	sync.Mutex
+	agents map[string]*agentInfo
+	ids    map[string]map[string]struct{}
+}
+
+func newAgentRegistry() *agentRegistry {
+	return &agentRegistry{
+		agents: map[string]*agentInfo{},
+		ids:    map[string]map[string]struct{}{},
+	}
+}
+
+func (ar *agentRegistry) observe(header http.Header, remoteAddr string, batch []metric.Metric) {
+	if ar == nil {
+		return
+	}
+	id := header.Get(metric.AgentIDHeader)
+	if id == "" {
+		return
+	}
+
+	ar.Lock()
+	defer ar.Unlock()
+
+	now := time.Now()
+	a, ok := ar.agents[id]
+	if !ok {
+		a = &agentInfo{
+			ID:        id,
+			FirstSeen: now,
+		}
+		ar.agents[id] = a
+		ar.ids[id] = map[string]struct{}{}
+	}
+	a.LastSeen = now
+	a.Version = header.Get(metric.AgentVersionHeader)
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		a.Addr = host
+	} else if remoteAddr != "" {
+		a.Addr = remoteAddr
+	}
+	a.Reported += int64(len(batch))
+	for i := range batch {
+		ar.ids[id][batch[i].ID] = struct{}{}
+		if len(batch[i].Labels) > 0 {
+			a.Labels = batch[i].Labels
+		}
+	}
+	a.Metrics = len(ar.ids[id])
+}
+
+func (ar *agentRegistry) list() []agentInfo {
+	ar.Lock()
+	defer ar.Unlock()
+
+	res := make([]agentInfo, 0, len(ar.agents))
+	for _, a := range ar.agents {
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (srv *ServerConfig) handlerGetAgents(w http.ResponseWriter, r *http.Request) {
+	mj, err := json.Marshal(srv.agents.list())
+	if err != nil {
+		writeError(w, r, clog.ToLog(clog.FuncName(), err))
+		return
+	}
+	w.Header().Set("Content-Type", JSONCT)
+	w.Write(mj)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentRegistry(t *testing.T) {
+	ar := newAgentRegistry()
+	header := http.Header{}
+	header.Set(metric.AgentIDHeader, "a1")
+	header.Set(metric.AgentVersionHeader, "v1")
+	labels := map[string]string{"dc": "eu"}
+
+	ar.observe(http.Header{}, "10.0.0.1:5000", []metric.Metric{{ID: "Alloc"}})
+	assert.Empty(t, ar.list(), "anonymous requests are not registered")
+
+	ar.observe(header, "10.0.0.1:5000", []metric.Metric{{ID: "Alloc", Labels: labels}, {ID: "PollCount"}})
+	header.Set(metric.AgentVersionHeader, "v2")
+	ar.observe(header, "10.0.0.2", []metric.Metric{{ID: "Alloc"}})
+
+	agents := ar.list()
+	require.Len(t, agents, 1)
+	a := agents[0]
+	assert.Equal(t, "a1", a.ID)
+	assert.Equal(t, "v2", a.Version)
+	assert.Equal(t, "10.0.0.2", a.Addr)
+	assert.Equal(t, labels, a.Labels, "labels are kept when a later batch has none")
+	assert.Equal(t, int64(3), a.Reported)
+	assert.Equal(t, 2, a.Metrics)
+	assert.False(t, a.LastSeen.Before(a.FirstSeen))
+
+	header.Set(metric.AgentIDHeader, "a0")
+	ar.observe(header, "10.0.0.3:5000", nil)
+	agents = ar.list()
+	require.Len(t, agents, 2)
+	assert.Equal(t, "a0", agents[0].ID)
+}
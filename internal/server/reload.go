@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/config"
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+// hotKeys are the settings applied without a restart. Changes of any other
+// setting, such as the listen address or the storage, are reported and
+// wait for the next start. The server has no trusted subnets, tokens, rate
+// limits or alert rules yet; whatever adds them should list them here.
+var hotKeys = map[string]bool{
+	"key":                   true,
+	"max_body_size":         true,
+	"store_interval":        true,
+	"scrape_agents":         true,
+	"scrape_targets":        true,
+	"scrape_interval":       true,
+	"self_metrics_interval": true,
+	"ready_timeout":         true,
+	"ready_upload_age":      true,
+	"ready_pool_saturation": true,
+	"log_level":             true,
+	"log_format":            true,
+	"log_output":            true,
+}
+
+// cfg returns the running config, which may differ from Cfg after reloads.
+func (srv *ServerConfig) cfg() *EnvConfig {
+	if cfg, ok := srv.live.Load().(*EnvConfig); ok {
+		return cfg
+	}
+	return &srv.Cfg
+}
+
+// subscribeReload returns a channel notified after every applied reload, so
+// that loops can pick up new intervals.
+func (srv *ServerConfig) subscribeReload() <-chan struct{} {
+	srv.reloadMu.Lock()
+	defer srv.reloadMu.Unlock()
+	ch := make(chan struct{}, 1)
+	srv.reloadSubs = append(srv.reloadSubs, ch)
+	return ch
+}
+
+// reload reads the config again the same way as on start and applies the
+// hot settings. The running config is kept when the new one is invalid.
+func (srv *ServerConfig) reload() error {
+	srv.reloadMu.Lock()
+	defer srv.reloadMu.Unlock()
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	loaded, err := srv.readConfig(fs)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+
+	current := srv.cfg()
+	applied, restart := []string{}, []string{}
+	for _, key := range config.Diff(current, &loaded) {
+		if hotKeys[key] {
+			applied = append(applied, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+	next := *current
+	config.Copy(&next, &loaded, applied)
+	if next.LogLevel != current.LogLevel || next.LogFormat != current.LogFormat || next.LogOutput != current.LogOutput {
+		if err := clog.Setup(next.LogLevel, next.LogFormat, next.LogOutput); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	srv.live.Store(&next)
+	for _, ch := range srv.reloadSubs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	clog.Info("config reloaded", clog.F("applied", applied))
+	if len(restart) > 0 {
+		clog.Warn("config changes require restart", clog.F("keys", restart))
+	}
+	return nil
+}
+
+// handleSignals reloads the config on SIGHUP and shuts the server down on
+// the others, closing done once in-flight requests are served.
+func (srv *ServerConfig) handleSignals(stop func(ctx context.Context) error, done chan<- struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	for sig := range sigCh {
+		if sig == syscall.SIGHUP {
+			if err := srv.reload(); err != nil {
+				clog.Error("config reload", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			}
+			continue
+		}
+		clog.Info("shutting down", clog.F("signal", sig))
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := stop(ctx); err != nil {
+			clog.Error("shutdown", clog.Err(clog.ToLog(clog.FuncName(), err)))
+		}
+		cancel()
+		close(done)
+		return
+	}
+}
+
+// runUploads saves the file storage every store interval or, when it is
+// zero, after every update.
+func (srv *ServerConfig) runUploads(st *internalstorage.MetricStorage) {
+	reloaded := srv.subscribeReload()
+	ticker := newTicker(srv.cfg().StoreInterval)
+	for {
+		select {
+		case <-tickC(ticker):
+			srv.uploadStorage(st)
+		case <-srv.Cfg.SyncUpload:
+			srv.uploadStorage(st)
+		case <-reloaded:
+			stopTicker(ticker)
+			ticker = newTicker(srv.cfg().StoreInterval)
+		}
+	}
+}
+
+// syncUpload requests an upload after an update when uploads are not periodic.
+func (srv *ServerConfig) syncUpload() {
+	if srv.Cfg.SyncUpload != nil && srv.cfg().StoreInterval == 0 {
+		var tmp struct{}
+		srv.Cfg.SyncUpload <- tmp
+	}
+}
+
+func newTicker(d time.Duration) *time.Ticker {
+	if d <= 0 {
+		return nil
+	}
+	return time.NewTicker(d)
+}
+
+// tickC returns the ticker channel, nil for a disabled ticker, so that the
+// select case never fires.
+func tickC(t *time.Ticker) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+func stopTicker(t *time.Ticker) {
+	if t != nil {
+		t.Stop()
+	}
+}
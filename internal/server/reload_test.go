@@ -0,0 +1,42 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	write := func(data string) {
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+	t.Setenv("CONFIG", path)
+	for _, name := range []string{"ADDRESS", "KEY", "STORE_INTERVAL"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	srv := &ServerConfig{}
+	srv.Cfg = EnvConfig{SrvAddr: "localhost:8080", LogLevel: "info", EnvConfig: true}
+	write("key: old\nstore_interval: 10s\n")
+	require.NoError(t, srv.GetExternalConfig())
+	live := srv.Cfg
+	srv.live.Store(&live)
+	reloaded := srv.subscribeReload()
+
+	write("key: new\nstore_interval: 0s\naddress: localhost:9090\n")
+	require.NoError(t, srv.reload())
+	assert.Equal(t, "new", srv.cfg().HashKey)
+	assert.Equal(t, time.Duration(0), srv.cfg().StoreInterval)
+	assert.Equal(t, "localhost:8080", srv.cfg().SrvAddr, "address needs a restart")
+	assert.Len(t, reloaded, 1)
+
+	write("store_interval: -1s\n")
+	assert.Error(t, srv.reload())
+	assert.Equal(t, "new", srv.cfg().HashKey, "invalid config is not applied")
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/dcaiman/YP_GO/internal/prom"
+)
+
+const scrapeBodyLimit = 16 << 20
+
+type scraper struct {
+	srv    *ServerConfig
+	client *http.Client
+
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+func (cfg *EnvConfig) scrapeInterval() time.Duration {
+	if cfg.ScrapeInterval <= 0 {
+		return 10 * time.Second
+	}
+	return cfg.ScrapeInterval
+}
+
+// startScraper runs even without targets, as they can be added on reload.
+func (srv *ServerConfig) startScraper() {
+	sc := &scraper{
+		srv:    srv,
+		client: &http.Client{},
+		last:   map[string]int64{},
+	}
+	reloaded := srv.subscribeReload()
+	go func() {
+		scrapeTimer := time.NewTicker(srv.cfg().scrapeInterval())
+		for {
+			select {
+			case <-scrapeTimer.C:
+				sc.scrapeAll()
+			case <-reloaded:
+				scrapeTimer.Reset(srv.cfg().scrapeInterval())
+			}
+		}
+	}()
+}
+
+func (sc *scraper) scrapeAll() {
+	cfg := sc.srv.cfg()
+	var wg sync.WaitGroup
+	for i := range cfg.ScrapeAgents {
+		target := strings.TrimSpace(cfg.ScrapeAgents[i])
+		if target == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if err := sc.scrapeAgent(target); err != nil {
+				clog.Error("scrape failed", clog.F("target", target), clog.Err(clog.ToLog(clog.FuncName(), err)))
+			}
+		}(target)
+	}
+	for i := range cfg.ScrapeTargets {
+		target := strings.TrimSpace(cfg.ScrapeTargets[i])
+		if target == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if err := sc.scrapeExporter(target); err != nil {
+				clog.Error("scrape failed", clog.F("target", target), clog.Err(clog.ToLog(clog.FuncName(), err)))
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// fetch gives up after one scrape interval.
+func (sc *scraper) fetch(url string) ([]byte, http.Header, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sc.srv.cfg().scrapeInterval())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, clog.ToLog(clog.FuncName(), err)
+	}
+	res, err := sc.client.Do(req)
+	if err != nil {
+		return nil, nil, clog.ToLog(clog.FuncName(), err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, clog.ToLog(clog.FuncName(), errors.New("unexpected response status <"+res.Status+">"))
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, scrapeBodyLimit))
+	if err != nil {
+		return nil, nil, clog.ToLog(clog.FuncName(), err)
+	}
+	return body, res.Header, nil
+}
+
+// targetURL adds the default scheme to a target without one and the
+// default path to a target without one.
+func targetURL(target, path string) string {
+	if !strings.Contains(target, "://") {
+		target = HTTPStr + target
+	}
+	_, rest, _ := strings.Cut(target, "://")
+	if i := strings.IndexByte(rest, '/'); i < 0 || rest[i:] == "/" {
+		return strings.TrimSuffix(target, "/") + path
+	}
+	return target
+}
+
+// scrapeAgent pulls the JSON endpoint of an agent running in pull mode.
+// Agents expose cumulative counters, so only the growth since the previous
+// scrape is stored; an agent seen for the first time or restarted
+// contributes its whole value.
+func (sc *scraper) scrapeAgent(target string) error {
+	body, header, err := sc.fetch(targetURL(target, "/metrics.json"))
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	pulled := []metric.Metric{}
+	if err := json.Unmarshal(body, &pulled); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+
+	batch := []metric.Metric{}
+	observed := []metric.Metric{}
+	for i := range pulled {
+		m := pulled[i]
+		if _, err := sc.srv.checkHash(m); err != nil {
+			return clog.ToLog(clog.FuncName(), err)
+		}
+		m.Hash = ""
+		observed = append(observed, m)
+		m.Labels = nil
+		if err := checkTypeSupport(m.MType); err != nil {
+			continue
+		}
+		switch m.MType {
+		case Gauge:
+			if m.Value == nil {
+				continue
+			}
+		case Counter:
+			if m.Delta == nil {
+				continue
+			}
+			del := sc.counterDelta(target, m.ID, *m.Delta)
+			if del == 0 {
+				continue
+			}
+			m.Delta = &del
+		}
+		batch = append(batch, m)
+	}
+	sc.srv.agents.observe(header, target, observed)
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := sc.srv.Storage.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	sc.srv.syncUpload()
+	return nil
+}
+
+// scrapeExporter pulls a Prometheus text endpoint, given as [prefix=]url.
+// Labels become part of the metric ID. The family type decides the metric
+// type, so a series never switches between counter and gauge. Counters are
+// stored as deltas between scrapes in whole units: the fraction of a
+// counter such as CPU seconds carries over into later deltas.
+func (sc *scraper) scrapeExporter(target string) error {
+	prefix, url := "", target
+	if name, rest, ok := strings.Cut(target, "="); ok && !strings.ContainsAny(name, ":/") {
+		prefix, url = name, rest
+	}
+	body, _, err := sc.fetch(targetURL(url, "/metrics"))
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	samples, err := prom.Parse(bytes.NewReader(body))
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+
+	batch := []metric.Metric{}
+	for i := range samples {
+		v := samples[i].Value
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		id := prefix + samples[i].ID()
+		if samples[i].Type == "counter" {
+			if v < 0 || v >= math.MaxInt64 {
+				continue
+			}
+			del := sc.counterDelta(target, id, int64(v))
+			if del == 0 {
+				continue
+			}
+			batch = append(batch, metric.Metric{
+				ID:    id,
+				MType: Counter,
+				Delta: &del,
+			})
+			continue
+		}
+		batch = append(batch, metric.Metric{
+			ID:    id,
+			MType: Gauge,
+			Value: &v,
+		})
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := sc.srv.Storage.UpdateBatch(batch); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	sc.srv.syncUpload()
+	return nil
+}
+
+func (sc *scraper) counterDelta(target, id string, cur int64) int64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	key := target + "\x00" + id
+	prev, ok := sc.last[key]
+	sc.last[key] = cur
+	if !ok || cur < prev {
+		return cur
+	}
+	return cur - prev
+}
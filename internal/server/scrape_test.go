@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/internalstorage"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestScraper() (*scraper, *internalstorage.MetricStorage) {
+	st := internalstorage.New("", "")
+	srv := &ServerConfig{Storage: st, agents: newAgentRegistry()}
+	return &scraper{srv: srv, client: &http.Client{}, last: map[string]int64{}}, st
+}
+
+func TestScrapeExporterFractionalCounter(t *testing.T) {
+	values := []string{"1.5", "2", "3.75"}
+	scrape := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# TYPE process_cpu_seconds_total counter\nprocess_cpu_seconds_total %s\n", values[scrape])
+		scrape++
+	}))
+	defer ts.Close()
+
+	sc, st := newTestScraper()
+	for range values {
+		require.NoError(t, sc.scrapeExporter(ts.URL+"/metrics"))
+		m, err := st.GetMetric("process_cpu_seconds_total")
+		require.NoError(t, err)
+		assert.Equal(t, Counter, m.MType)
+	}
+	m, err := st.GetMetric("process_cpu_seconds_total")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *m.Delta, "whole units of the last value")
+}
+
+func TestTargetURL(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"host:9100", "http://host:9100/metrics.json"},
+		{"http://host:9100", "http://host:9100/metrics.json"},
+		{"https://host:9100/", "https://host:9100/metrics.json"},
+		{"host:9100/custom", "http://host:9100/custom"},
+		{"http://host:9100/agent/metrics.json", "http://host:9100/agent/metrics.json"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, targetURL(tt.target, "/metrics.json"), tt.target)
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	sc, _ := newTestScraper()
+	tests := []struct {
+		name   string
+		target string
+		cur    int64
+		want   int64
+	}{
+		{"first scrape counts in full", "a", 10, 10},
+		{"growth", "a", 15, 5},
+		{"no growth", "a", 15, 0},
+		{"restart counts in full", "a", 3, 3},
+		{"targets are separate", "b", 7, 7},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sc.counterDelta(tt.target, "PollCount", tt.cur), tt.name)
+	}
+}
+
+func TestScrapeAgent(t *testing.T) {
+	poll := int64(4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/metrics.json", r.URL.Path)
+		w.Header().Set(metric.AgentIDHeader, "a1")
+		fmt.Fprintf(w, `[{"id":"PollCount","type":"counter","delta":%d,"labels":{"dc":"eu"}},`+
+			`{"id":"Alloc","type":"gauge","value":1.5},{"id":"Pending","type":"gauge"},`+
+			`{"id":"Hist","type":"histogram","value":1}]`, poll)
+	}))
+	defer ts.Close()
+
+	sc, st := newTestScraper()
+	require.NoError(t, sc.scrapeAgent(ts.URL))
+	poll = 10
+	require.NoError(t, sc.scrapeAgent(ts.URL))
+
+	m, err := st.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *m.Delta, "cumulative counters are stored once")
+	assert.Nil(t, m.Labels)
+	m, err = st.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, *m.Value)
+	_, err = st.GetMetric("Pending")
+	assert.Error(t, err, "metrics without a value are skipped")
+	_, err = st.GetMetric("Hist")
+	assert.Error(t, err, "unsupported types are skipped")
+
+	agents := sc.srv.agents.list()
+	require.Len(t, agents, 1)
+	assert.Equal(t, map[string]string{"dc": "eu"}, agents[0].Labels)
+
+	sc.srv.Cfg.HashKey = "key"
+	assert.Error(t, sc.scrapeAgent(ts.URL), "unsigned metrics are rejected with a key set")
+}
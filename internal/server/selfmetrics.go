@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/metric"
+	"github.com/dcaiman/YP_GO/internal/prom"
+	"github.com/go-chi/chi/v5"
+)
+
+// SelfMetricsPrefix starts the IDs of the server's own metrics. Clients
+// cannot write metrics under it.
+const SelfMetricsPrefix = "_server_"
+
+const selfRateWindow = 10 * time.Second
+
+type selfSeries struct {
+	name   string
+	labels map[string]string
+	mtype  string
+	value  float64
+	delta  int64
+}
+
+// selfMetrics keeps the operational metrics of the server. All methods are
+// safe to call on a nil receiver, so handlers work without instrumentation.
+type selfMetrics struct {
+	mu     sync.Mutex
+	series map[string]*selfSeries
+
+	dbStats func() sql.DBStats
+
+	rateAt   time.Time
+	rateBase int64
+
+	// counter values already written into the storage
+	written map[string]int64
+}
+
+func newSelfMetrics() *selfMetrics {
+	return &selfMetrics{
+		series:  map[string]*selfSeries{},
+		written: map[string]int64{},
+		rateAt:  time.Now(),
+	}
+}
+
+func seriesID(name string, labels map[string]string) string {
+	s := prom.Sample{Name: SelfMetricsPrefix + name}
+	for k, v := range labels {
+		s.Labels = append(s.Labels, prom.Label{Name: k, Value: v})
+	}
+	sort.Slice(s.Labels, func(i, j int) bool { return s.Labels[i].Name < s.Labels[j].Name })
+	return s.ID()
+}
+
+func (sm *selfMetrics) get(name, mtype string, labels map[string]string) *selfSeries {
+	id := seriesID(name, labels)
+	s, ok := sm.series[id]
+	if !ok {
+		s = &selfSeries{name: SelfMetricsPrefix + name, labels: labels, mtype: mtype}
+		sm.series[id] = s
+	}
+	return s
+}
+
+func (sm *selfMetrics) add(name string, labels map[string]string, delta int64) {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.get(name, Counter, labels).delta += delta
+}
+
+func (sm *selfMetrics) set(name string, labels map[string]string, v float64) {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.get(name, Gauge, labels).value = v
+}
+
+// observeBatch records metrics accepted by the update handlers.
+func (sm *selfMetrics) observeBatch(size int, batch bool) {
+	if sm == nil {
+		return
+	}
+	sm.add("ingested_metrics_total", nil, int64(size))
+	if batch {
+		sm.add("batches_total", nil, 1)
+		sm.add("batch_metrics_total", nil, int64(size))
+		sm.set("batch_size_last", nil, float64(size))
+	}
+}
+
+func (sm *selfMetrics) observeUpload(d time.Duration, err error) {
+	if sm == nil {
+		return
+	}
+	sm.add("uploads_total", nil, 1)
+	if err != nil {
+		sm.add("upload_errors_total", nil, 1)
+	}
+	sm.set("upload_duration_seconds", nil, d.Seconds())
+}
+
+// updateRate turns the ingested counter into metrics per second over the
+// time passed since the previous call.
+func (sm *selfMetrics) updateRate() {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	now := time.Now()
+	total := sm.get("ingested_metrics_total", Counter, nil).delta
+	if elapsed := now.Sub(sm.rateAt).Seconds(); elapsed > 0 {
+		sm.get("ingest_rate", Gauge, nil).value = float64(total-sm.rateBase) / elapsed
+	}
+	sm.rateAt, sm.rateBase = now, total
+}
+
+// snapshot returns the current values, IDs carry no labels, these are kept
+// in the Labels field for the exposition format.
+func (sm *selfMetrics) snapshot() []metric.Metric {
+	if sm == nil {
+		return nil
+	}
+	if sm.dbStats != nil {
+		st := sm.dbStats()
+		sm.set("db_open_connections", nil, float64(st.OpenConnections))
+		sm.set("db_in_use_connections", nil, float64(st.InUse))
+		sm.set("db_idle_connections", nil, float64(st.Idle))
+		sm.set("db_wait_count", nil, float64(st.WaitCount))
+		sm.set("db_wait_duration_seconds", nil, st.WaitDuration.Seconds())
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	res := make([]metric.Metric, 0, len(sm.series))
+	for _, s := range sm.series {
+		m := metric.Metric{ID: s.name, MType: s.mtype, Labels: s.labels}
+		if s.mtype == Counter {
+			del := s.delta
+			m.Delta = &del
+		} else {
+			val := s.value
+			m.Value = &val
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// storageBatch returns the metrics to write into the storage, with labels
+// folded into the IDs and counters as deltas since the previous write.
+func (sm *selfMetrics) storageBatch() []metric.Metric {
+	snap := sm.snapshot()
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	batch := make([]metric.Metric, 0, len(snap))
+	for i := range snap {
+		m := snap[i]
+		labels := m.Labels
+		m.Labels = nil
+		id := prom.Sample{Name: m.ID}
+		for k, v := range labels {
+			id.Labels = append(id.Labels, prom.Label{Name: k, Value: v})
+		}
+		sort.Slice(id.Labels, func(i, j int) bool { return id.Labels[i].Name < id.Labels[j].Name })
+		m.ID = id.ID()
+		if m.MType == Counter {
+			del := *m.Delta - sm.written[m.ID]
+			if del == 0 {
+				continue
+			}
+			sm.written[m.ID] = *m.Delta
+			m.Delta = &del
+		}
+		batch = append(batch, m)
+	}
+	return batch
+}
+
+func (srv *ServerConfig) runSelfMetrics() {
+	reloaded := srv.subscribeReload()
+	rateTimer := time.NewTicker(selfRateWindow)
+	writeTimer := newTicker(srv.cfg().SelfMetricsInterval)
+	for {
+		select {
+		case <-rateTimer.C:
+			srv.self.updateRate()
+		case <-reloaded:
+			stopTicker(writeTimer)
+			writeTimer = newTicker(srv.cfg().SelfMetricsInterval)
+		case <-tickC(writeTimer):
+			batch := srv.self.storageBatch()
+			if len(batch) == 0 {
+				continue
+			}
+			if err := srv.Storage.UpdateBatch(batch); err != nil {
+				clog.Error("self metrics", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			}
+		}
+	}
+}
+
+func (srv *ServerConfig) handlerSelfMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", prom.ContentType)
+	if err := prom.WriteText(w, srv.self.snapshot()); err != nil {
+		logRequestError(r, clog.ToLog(clog.FuncName(), err), http.StatusInternalServerError)
+	}
+}
+
+// instrument counts requests per route, method and status.
+func (srv *ServerConfig) instrument(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// unmatched paths are folded into one route to bound the series count
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		labels := map[string]string{
+			"route":  route,
+			"method": r.Method,
+			"status": strconv.Itoa(rec.status),
+		}
+		srv.self.add("http_requests_total", labels, 1)
+		srv.self.add("http_request_duration_microseconds_total", map[string]string{"route": route}, time.Since(start).Microseconds())
+	})
+}
+
+func checkReservedID(id string) error {
+	if strings.HasPrefix(id, SelfMetricsPrefix) {
+		return clog.ToLog(clog.FuncName(), clog.NewKind(clog.KindInvalidInput, "metric <"+id+"> uses the reserved prefix <"+SelfMetricsPrefix+">"))
+	}
+	return nil
+}
+
+// instrumentedStorage measures the calls of the wrapped storage.
+type instrumentedStorage struct {
+	metric.MStorage
+	self *selfMetrics
+}
+
+func (st *instrumentedStorage) observe(method string, start time.Time, err error) {
+	labels := map[string]string{"method": method}
+	st.self.add("storage_calls_total", labels, 1)
+	st.self.add("storage_latency_microseconds_total", labels, time.Since(start).Microseconds())
+	if err != nil && !clog.IsKind(err, clog.KindNotFound) {
+		st.self.add("storage_errors_total", labels, 1)
+	}
+}
+
+func (st *instrumentedStorage) GetMetric(id string) (metric.Metric, error) {
+	start := time.Now()
+	m, err := st.MStorage.GetMetric(id)
+	st.observe("GetMetric", start, err)
+	return m, err
+}
+
+func (st *instrumentedStorage) GetBatch() ([]metric.Metric, error) {
+	start := time.Now()
+	batch, err := st.MStorage.GetBatch()
+	st.observe("GetBatch", start, err)
+	return batch, err
+}
+
+func (st *instrumentedStorage) UpdateMetric(m metric.Metric) error {
+	start := time.Now()
+	err := st.MStorage.UpdateMetric(m)
+	st.observe("UpdateMetric", start, err)
+	return err
+}
+
+func (st *instrumentedStorage) UpdateBatch(batch []metric.Metric) error {
+	start := time.Now()
+	err := st.MStorage.UpdateBatch(batch)
+	st.observe("UpdateBatch", start, err)
+	return err
+}
+
+func (st *instrumentedStorage) AccessCheck(ctx context.Context) error {
+	start := time.Now()
+	err := st.MStorage.AccessCheck(ctx)
+	st.observe("AccessCheck", start, err)
+	return err
+}
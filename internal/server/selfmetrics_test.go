@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dcaiman/YP_GO/internal/prom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfMetricsStorageBatch(t *testing.T) {
+	sm := newSelfMetrics()
+	sm.add("http_requests_total", map[string]string{"route": "/update/", "status": "200"}, 2)
+	sm.set("batch_size_last", nil, 10)
+
+	batch := sm.storageBatch()
+	require.Len(t, batch, 2)
+	byID := map[string]int{}
+	for i := range batch {
+		assert.Nil(t, batch[i].Labels)
+		byID[batch[i].ID] = i
+	}
+	i, ok := byID[`_server_http_requests_total{route="/update/",status="200"}`]
+	require.True(t, ok)
+	assert.Equal(t, int64(2), *batch[i].Delta)
+
+	// only the increase since the previous write is stored
+	sm.add("http_requests_total", map[string]string{"route": "/update/", "status": "200"}, 3)
+	batch = sm.storageBatch()
+	for _, m := range batch {
+		if m.MType == Counter {
+			assert.Equal(t, int64(3), *m.Delta)
+		}
+	}
+}
+
+func TestSelfMetricsExposition(t *testing.T) {
+	sm := newSelfMetrics()
+	sm.add("storage_calls_total", map[string]string{"method": "GetMetric"}, 1)
+	sm.add("storage_calls_total", map[string]string{"method": "UpdateBatch"}, 4)
+
+	var buf bytes.Buffer
+	require.NoError(t, prom.WriteText(&buf, sm.snapshot()))
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("# TYPE")))
+	samples, err := prom.Parse(&buf)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Nil(t, (*selfMetrics)(nil).snapshot())
+}
+
+func TestReservedPrefix(t *testing.T) {
+	assert.Error(t, checkReservedID(SelfMetricsPrefix+"x"))
+	assert.NoError(t, checkReservedID("Alloc"))
+}
@@ -1,14 +1,22 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 
 	"github.com/dcaiman/YP_GO/internal/clog"
+	"github.com/dcaiman/YP_GO/internal/config"
 	"github.com/dcaiman/YP_GO/internal/internalstorage"
 	"github.com/dcaiman/YP_GO/internal/metric"
 	"github.com/dcaiman/YP_GO/internal/pgxstorage"
@@ -21,12 +29,31 @@ type EnvConfig struct {
 	SrvAddr       string        `env:"ADDRESS"`
 	StoreFile     string        `env:"STORE_FILE"`
 	DBAddr        string        `env:"DATABASE_DSN"`
+	DBMaxConns    int           `env:"DATABASE_MAX_CONNS"`
 	StoreInterval time.Duration `env:"STORE_INTERVAL"`
 	InitDownload  bool          `env:"RESTORE"`
 	HashKey       string        `env:"KEY"`
+	MaxBodySize   int64         `env:"MAX_BODY_SIZE"`
+
+	ScrapeAgents   []string      `env:"SCRAPE_AGENTS"`
+	ScrapeTargets  []string      `env:"SCRAPE_TARGETS"`
+	ScrapeInterval time.Duration `env:"SCRAPE_INTERVAL"`
+
+	SelfMetricsInterval time.Duration `env:"SELF_METRICS_INTERVAL"`
+
+	ReadyTimeout        time.Duration `env:"READY_TIMEOUT"`
+	ReadyUploadAge      time.Duration `env:"READY_UPLOAD_AGE"`
+	ReadyPoolSaturation float64       `env:"READY_POOL_SATURATION"`
+
+	LogLevel  string `env:"LOG_LEVEL"`
+	LogFormat string `env:"LOG_FORMAT"`
+	LogOutput string `env:"LOG_OUTPUT"`
 
 	SyncUpload chan struct{}
 
+	ConfigFile  string
+	PrintConfig bool
+
 	EnvConfig bool
 	ArgConfig bool
 	DropDB    bool
@@ -35,53 +62,45 @@ type EnvConfig struct {
 type ServerConfig struct {
 	Storage metric.MStorage
 	Cfg     EnvConfig
+
+	agents *agentRegistry
+	self   *selfMetrics
+	health *healthState
+
+	// defaults are the settings before the config file, env and flags,
+	// kept to read the config again on reload
+	defaults   EnvConfig
+	live       atomic.Value
+	reloadMu   sync.Mutex
+	reloadSubs []chan struct{}
 }
 
 func RunServer(srv *ServerConfig) {
-	if srv.Cfg.DBAddr != "" {
-		dbStorage, err := pgxstorage.New(srv.Cfg.DBAddr, srv.Cfg.DropDB)
-		if err != nil {
-			log.Println(clog.ToLog(clog.FuncName(), err))
-		}
-		defer dbStorage.Close()
-		srv.Storage = dbStorage
-	} else if srv.Cfg.StoreFile != "" {
-		fileStorage := internalstorage.New(srv.Cfg.StoreFile, srv.Cfg.HashKey)
-
-		if srv.Cfg.InitDownload {
-			err := fileStorage.DownloadStorage()
-			if err != nil {
-				log.Println(clog.ToLog(clog.FuncName(), err))
-			}
-		}
-		if srv.Cfg.StoreInterval != 0 {
-			go func() {
-				uploadTimer := time.NewTicker(srv.Cfg.StoreInterval)
-				for {
-					<-uploadTimer.C
-					if err := fileStorage.UploadStorage(); err != nil {
-						log.Println(clog.ToLog(clog.FuncName(), err))
-					}
-				}
-			}()
-		} else {
-			srv.Cfg.SyncUpload = make(chan struct{})
-			go func(c chan struct{}) {
-				for {
-					<-c
-					if err := fileStorage.UploadStorage(); err != nil {
-						log.Println(clog.ToLog(clog.FuncName(), err))
-					}
-				}
-			}(srv.Cfg.SyncUpload)
-		}
-		srv.Storage = fileStorage
+	srv.self = newSelfMetrics()
+	srv.health = newHealthState()
+	fileStorage, closeStorage := srv.openStorage()
+	defer closeStorage()
+	live := srv.Cfg
+	srv.live.Store(&live)
+	if fileStorage != nil {
+		go srv.runUploads(fileStorage)
 	}
 
-	log.Println("SERVER CONFIG: ", srv.Cfg)
+	clog.Info("server config", clog.F("config", fmt.Sprintf("%+v", srv.Cfg)))
+
+	if srv.Storage != nil {
+		srv.Storage = &instrumentedStorage{MStorage: srv.Storage, self: srv.self}
+	}
+	go srv.runSelfMetrics()
+
+	srv.agents = newAgentRegistry()
+
+	srv.startScraper()
 
 	mainRouter := chi.NewRouter()
-	mainRouter.Use(Compresser)
+	mainRouter.Use(RequestID, AccessLog, srv.instrument, srv.Compresser)
+	mainRouter.NotFound(handlerNotFound)
+	mainRouter.MethodNotAllowed(handlerMethodNotAllowed)
 	mainRouter.Route("/", func(r chi.Router) {
 		r.Get("/", srv.handlerGetAll)
 	})
@@ -96,26 +115,222 @@ func RunServer(srv *ServerConfig) {
 	mainRouter.Route("/updates", func(r chi.Router) {
 		r.Post("/", srv.handlerUpdateBatch)
 	})
+	mainRouter.Route("/agents", func(r chi.Router) {
+		r.Get("/", srv.handlerGetAgents)
+	})
+	mainRouter.Get("/metrics", srv.handlerSelfMetrics)
+	mainRouter.Get("/healthz", srv.handlerHealthz)
+	mainRouter.Get("/readyz", srv.handlerReadyz)
 	mainRouter.Route("/ping", func(r chi.Router) {
 		r.Get("/", srv.handlerCheckDBConnection)
 	})
-	log.Println(http.ListenAndServe(srv.Cfg.SrvAddr, mainRouter))
+	httpServer := &http.Server{Addr: srv.Cfg.SrvAddr, Handler: mainRouter}
+	stopped := make(chan struct{})
+	go srv.handleSignals(httpServer.Shutdown, stopped)
+
+	clog.Info("server listening", clog.F("addr", srv.Cfg.SrvAddr))
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		clog.Error("server stopped", clog.Err(err))
+		return
+	}
+	<-stopped
+	// in-memory data would be lost otherwise
+	if fileStorage != nil {
+		srv.uploadStorage(fileStorage)
+	}
+	clog.Info("server stopped")
 }
 
+// openStorage sets up the storage of the config and returns the file
+// storage, if that is the one in use, to upload it on exit. A database that
+// cannot be set up does not stop the server: requests fail as unavailable
+// and the readiness check reports the error until the server is restarted.
+func (srv *ServerConfig) openStorage() (*internalstorage.MetricStorage, func()) {
+	if srv.Cfg.DBAddr != "" {
+		dbStorage, err := pgxstorage.New(srv.Cfg.DBAddr, srv.Cfg.DBMaxConns, srv.Cfg.DropDB)
+		if err != nil {
+			clog.Error("database storage", clog.Err(clog.ToLog(clog.FuncName(), err)))
+			srv.Storage = unavailableStorage{err: err}
+			return nil, func() {}
+		}
+		srv.Storage = dbStorage
+		srv.self.dbStats = dbStorage.DB.Stats
+		return nil, func() { dbStorage.Close() }
+	}
+	if srv.Cfg.StoreFile == "" {
+		return nil, func() {}
+	}
+	fileStorage := internalstorage.New(srv.Cfg.StoreFile, srv.Cfg.HashKey)
+	srv.health.fileStorage = true
+
+	if srv.Cfg.InitDownload {
+		err := fileStorage.DownloadStorage()
+		if err != nil {
+			clog.Error("storage download", clog.F("file", srv.Cfg.StoreFile), clog.Err(clog.ToLog(clog.FuncName(), err)))
+		}
+	}
+	// the store interval can change on reload, so the channel for
+	// uploads after every update is always there
+	srv.Cfg.SyncUpload = make(chan struct{})
+	srv.Storage = fileStorage
+	return fileStorage, func() {}
+}
+
+// unavailableStorage stands in for a storage that failed to set up.
+type unavailableStorage struct {
+	err error
+}
+
+func (st unavailableStorage) unavailable() error {
+	return clog.WithKind(clog.KindUnavailable, errors.New("storage is unavailable: "+clog.Message(st.err)))
+}
+
+func (st unavailableStorage) GetMetric(id string) (metric.Metric, error) {
+	return metric.Metric{}, clog.ToLog(clog.FuncName(), st.unavailable())
+}
+
+func (st unavailableStorage) GetBatch() ([]metric.Metric, error) {
+	return nil, clog.ToLog(clog.FuncName(), st.unavailable())
+}
+
+func (st unavailableStorage) UpdateMetric(m metric.Metric) error {
+	return clog.ToLog(clog.FuncName(), st.unavailable())
+}
+
+func (st unavailableStorage) UpdateBatch(batch []metric.Metric) error {
+	return clog.ToLog(clog.FuncName(), st.unavailable())
+}
+
+func (st unavailableStorage) AccessCheck(ctx context.Context) error {
+	return clog.ToLog(clog.FuncName(), st.unavailable())
+}
+
+// GetExternalConfig applies, in order of precedence, the config file,
+// env variables and command line flags over the defaults.
 func (srv *ServerConfig) GetExternalConfig() error {
-	if srv.Cfg.ArgConfig {
-		flag.BoolVar(&srv.Cfg.InitDownload, "r", srv.Cfg.InitDownload, "initial download flag")
-		flag.StringVar(&srv.Cfg.StoreFile, "f", srv.Cfg.StoreFile, "storage file destination")
-		flag.StringVar(&srv.Cfg.SrvAddr, "a", srv.Cfg.SrvAddr, "server address")
-		flag.DurationVar(&srv.Cfg.StoreInterval, "i", srv.Cfg.StoreInterval, "store interval")
-		flag.StringVar(&srv.Cfg.HashKey, "k", srv.Cfg.HashKey, "hash key")
-		flag.StringVar(&srv.Cfg.DBAddr, "d", srv.Cfg.DBAddr, "database address")
-		flag.Parse()
-	}
-	if srv.Cfg.EnvConfig {
-		if err := env.Parse(&srv.Cfg); err != nil {
-			return clog.ToLog(clog.FuncName(), err)
+	srv.defaults = srv.Cfg
+	cfg, err := srv.readConfig(flag.CommandLine)
+	if err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	srv.Cfg = cfg
+	if err := clog.Setup(srv.Cfg.LogLevel, srv.Cfg.LogFormat, srv.Cfg.LogOutput); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
+	return nil
+}
+
+// readConfig layers the config file, env variables and the flags parsed by
+// fs over the defaults and validates the result.
+func (srv *ServerConfig) readConfig(fs *flag.FlagSet) (EnvConfig, error) {
+	cfg := srv.defaults
+	path := ""
+	if cfg.EnvConfig {
+		path = os.Getenv("CONFIG")
+	}
+	if cfg.ArgConfig {
+		// flags are applied last, only the file path is needed before that
+		scratch := cfg
+		pre := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		pre.SetOutput(io.Discard)
+		bindFlags(pre, &scratch)
+		pre.Parse(os.Args[1:])
+		if scratch.ConfigFile != "" {
+			path = scratch.ConfigFile
+		}
+	}
+	if path != "" {
+		if err := config.Load(path, &cfg); err != nil {
+			return cfg, clog.ToLog(clog.FuncName(), err)
+		}
+		cfg.ConfigFile = path
+	}
+	if cfg.EnvConfig {
+		if err := env.Parse(&cfg); err != nil {
+			return cfg, clog.ToLog(clog.FuncName(), err)
 		}
 	}
+	if cfg.ArgConfig {
+		bindFlags(fs, &cfg)
+		if err := fs.Parse(os.Args[1:]); err != nil {
+			return cfg, clog.ToLog(clog.FuncName(), err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, clog.ToLog(clog.FuncName(), err)
+	}
+	return cfg, nil
+}
+
+func bindFlags(fs *flag.FlagSet, cfg *EnvConfig) {
+	fs.StringVar(&cfg.ConfigFile, "c", cfg.ConfigFile, "config file, .json, .yaml or .yml")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", cfg.PrintConfig, "print the effective config and exit")
+	fs.BoolVar(&cfg.InitDownload, "r", cfg.InitDownload, "initial download flag")
+	fs.StringVar(&cfg.StoreFile, "f", cfg.StoreFile, "storage file destination")
+	fs.StringVar(&cfg.SrvAddr, "a", cfg.SrvAddr, "server address")
+	fs.DurationVar(&cfg.StoreInterval, "i", cfg.StoreInterval, "store interval")
+	fs.StringVar(&cfg.HashKey, "k", cfg.HashKey, "hash key")
+	fs.StringVar(&cfg.DBAddr, "d", cfg.DBAddr, "database address")
+	fs.Int64Var(&cfg.MaxBodySize, "max-body-size", cfg.MaxBodySize, "max request body size in bytes after decompression, 0 for no limit")
+	fs.IntVar(&cfg.DBMaxConns, "db-max-conns", cfg.DBMaxConns, "max open database connections, 0 for no limit")
+	fs.Func("scrape-agents", "comma separated list of agent pull endpoints", func(s string) error {
+		cfg.ScrapeAgents = strings.Split(s, ",")
+		return nil
+	})
+	fs.Func("scrape-targets", "comma separated list of prometheus endpoints, [prefix=]url", func(s string) error {
+		cfg.ScrapeTargets = strings.Split(s, ",")
+		return nil
+	})
+	fs.DurationVar(&cfg.ScrapeInterval, "scrape-interval", cfg.ScrapeInterval, "scrape interval")
+	fs.DurationVar(&cfg.ReadyTimeout, "ready-timeout", cfg.ReadyTimeout, "storage check timeout of the readiness probe")
+	fs.DurationVar(&cfg.ReadyUploadAge, "ready-upload-age", cfg.ReadyUploadAge, "max age of the last successful upload, 3 store intervals by default")
+	fs.Float64Var(&cfg.ReadyPoolSaturation, "ready-pool-saturation", cfg.ReadyPoolSaturation, "share of busy database connections that makes the server not ready")
+	fs.DurationVar(&cfg.SelfMetricsInterval, "self-metrics-interval", cfg.SelfMetricsInterval, "interval of writing own metrics into the storage, 0 to disable")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: logfmt or json")
+	fs.StringVar(&cfg.LogOutput, "log-output", cfg.LogOutput, "log output: stderr, stdout or file path")
+}
+
+// PrintConfig writes the effective config in the format of the config file,
+// YAML when there is none.
+func (srv *ServerConfig) PrintConfig(w io.Writer) error {
+	format := config.FormatYAML
+	if srv.Cfg.ConfigFile != "" {
+		if f, err := config.FormatOf(srv.Cfg.ConfigFile); err == nil {
+			format = f
+		}
+	}
+	if err := config.Write(w, &srv.Cfg, format); err != nil {
+		return clog.ToLog(clog.FuncName(), err)
+	}
 	return nil
 }
+
+func (srv *ServerConfig) uploadStorage(st *internalstorage.MetricStorage) {
+	start := time.Now()
+	err := st.UploadStorage()
+	srv.self.observeUpload(time.Since(start), err)
+	srv.health.observeUpload(err)
+	if err != nil {
+		clog.Error("storage upload", clog.F("file", srv.Cfg.StoreFile), clog.Err(clog.ToLog(clog.FuncName(), err)))
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (cfg *EnvConfig) Validate() error {
+	var p config.Problems
+	p.Check(config.ValidAddr(cfg.SrvAddr), "address <"+cfg.SrvAddr+"> is not host:port")
+	p.Check(cfg.DBMaxConns >= 0, "database_max_conns must not be negative")
+	p.Check(cfg.MaxBodySize >= 0, "max_body_size must not be negative")
+	p.Check(cfg.StoreInterval >= 0, "store_interval must not be negative")
+	p.Check(len(cfg.ScrapeAgents)+len(cfg.ScrapeTargets) == 0 || cfg.ScrapeInterval > 0, "scrape_interval must be positive when scraping")
+	p.Check(cfg.SelfMetricsInterval >= 0, "self_metrics_interval must not be negative")
+	p.Check(cfg.ReadyTimeout >= 0, "ready_timeout must not be negative")
+	p.Check(cfg.ReadyUploadAge >= 0, "ready_upload_age must not be negative")
+	p.Check(cfg.ReadyPoolSaturation >= 0 && cfg.ReadyPoolSaturation <= 1, "ready_pool_saturation must be within [0, 1]")
+	if _, err := clog.ParseLevel(cfg.LogLevel); err != nil {
+		p.Check(false, "log_level must be debug, info, warn or error, got <"+cfg.LogLevel+">")
+	}
+	p.Check(cfg.LogFormat == "" || cfg.LogFormat == clog.FormatLogfmt || cfg.LogFormat == clog.FormatJSON, "log_format must be logfmt or json, got <"+cfg.LogFormat+">")
+	return p.Err()
+}